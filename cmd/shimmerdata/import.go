@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ShimmerGames-Co-Ltd/shimmerdata-go/shimmerdata"
+)
+
+func runImport(args []string) error {
+	fs := newFlagSet("import", "<dir|file>...")
+	batch := addBatchFlags(fs)
+	format := fs.String("format", "", "ndjson or csv, detected by the file extension by default")
+	mappingPath := fs.String("mapping", "", "mapping file (see shimmerdata.ImportMapping), required by csv")
+	rate := fs.Float64("rate", 0, "max events per second, 0 means no limit")
+	checkpointDir := fs.String("checkpoint-dir", "", "directory of checkpoint files, default the directory of each file")
+	checkpointEvery := fs.Int("checkpoint-every", shimmerdata.DefaultCheckpointEvery, "save the progress every n events")
+	skipInvalid := fs.Bool("skip-invalid", false, "report and skip invalid records instead of stopping")
+	paths, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	config, err := batch.config()
+	if err != nil {
+		return err
+	}
+	var mapping *shimmerdata.ImportMapping
+	if *mappingPath != "" {
+		mapping, err = shimmerdata.LoadImportMapping(*mappingPath)
+		if err != nil {
+			return err
+		}
+	}
+	files, err := expand(paths)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := shimmerdata.NewBatchConsumer(config)
+	if err != nil {
+		return err
+	}
+	client := shimmerdata.New(consumer)
+	//中断时保存进度，下次使用相同的checkpoint继续导入
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, file := range files {
+		checkpoint := file + ".checkpoint"
+		if *checkpointDir != "" {
+			checkpoint, err = checkpointPath(*checkpointDir, file)
+			if err != nil {
+				return err
+			}
+		}
+		result, err := client.ImportFile(ctx, file, shimmerdata.ImportConfig{
+			Format:          shimmerdata.ImportFormat(*format),
+			Mapping:         mapping,
+			Rate:            *rate,
+			Checkpoint:      checkpoint,
+			CheckpointEvery: *checkpointEvery,
+			SkipInvalid:     *skipInvalid,
+			OnInvalid: func(line int, err error) {
+				fmt.Fprintf(os.Stderr, "%s:%d: %v\n", file, line, err)
+			},
+		})
+		fmt.Printf("%s: %d imported, %d invalid, %d resumed from checkpoint\n", file, result.Imported, result.Invalid, result.Resumed)
+		if err != nil {
+			//关闭时发送已导入的日志，发送失败的日志写入TempDir
+			_ = client.Close()
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return client.Close()
+}
+
+// checkpointPath checkpoint文件按完整路径区分，不同目录下同名的文件使用不同的checkpoint
+func checkpointPath(dir, file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(abs))
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.checkpoint", filepath.Base(file), hex.EncodeToString(sum[:8]))), nil
+}
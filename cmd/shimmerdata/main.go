@@ -0,0 +1,66 @@
+// shimmerdata 运维工具，用于查看和处理SDK的本地数据。
+//
+//	shimmerdata spool ls|cat|replay|purge [flags] [files]
+//	shimmerdata validate [flags] [files]
+//	shimmerdata import [flags] [files]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `usage: shimmerdata <command> [flags]
+
+commands:
+  spool ls       list files of the batch consumer TempDir with sizes, event counts and time ranges
+  spool cat      decompress spool files and print events
+  spool replay   upload spool files to the collector
+  spool purge    delete spool files matching filters
+  validate       check events in files of SDLogConsumer or the spool, exit with 1 if any event is invalid
+  import         import historical events from NDJSON or CSV files to the collector
+
+run "shimmerdata <command> -h" for the flags of a command.
+`
+
+// errUsage 参数错误，用法已经输出
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "spool":
+		err = runSpool(args[1:])
+	case "validate":
+		err = runValidate(args[1:])
+	case "import":
+		err = runImport(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err == errUsage {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
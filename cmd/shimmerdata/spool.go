@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ShimmerGames-Co-Ltd/shimmerdata-go/shimmerdata"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func runSpool(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	switch args[0] {
+	case "ls":
+		return spoolLs(args[1:])
+	case "cat":
+		return spoolCat(args[1:])
+	case "replay":
+		return spoolReplay(args[1:])
+	case "purge":
+		return spoolPurge(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown spool command %q\n\n%s", args[0], usage)
+		return errUsage
+	}
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: shimmerdata %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse 解析参数，至少需要一个文件或目录
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// collect 读取参数中的缓存文件，目录展开为其中的所有缓存文件
+func collect(paths []string) ([]shimmerdata.SpoolFile, error) {
+	var files []shimmerdata.SpoolFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			list, err := shimmerdata.ListSpool(path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, list...)
+			continue
+		}
+		f, err := shimmerdata.StatSpool(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// batchFlags 上传所需的配置，来自配置文件或命令行参数
+type batchFlags struct {
+	configPath *string
+	serverUrl  *string
+	appId      *string
+	appToken   *string
+	protocol   *int
+	sign       *bool
+}
+
+func addBatchFlags(fs *flag.FlagSet) *batchFlags {
+	return &batchFlags{
+		configPath: fs.String("config", "", "config file (see shimmerdata.LoadConfig) providing the batch consumer settings"),
+		serverUrl:  fs.String("url", "", "collector url, overrides the config"),
+		appId:      fs.String("app", "", "app id, overrides the config"),
+		appToken:   fs.String("token", "", "app token, overrides the config"),
+		protocol:   fs.Int("protocol", 0, "protocol version 1 or 2, overrides the config"),
+		sign:       fs.Bool("sign", false, "sign requests instead of sending the token"),
+	}
+}
+
+// config 读取配置文件的batch配置，再用命令行参数覆盖
+func (f *batchFlags) config() (shimmerdata.SDBatchConfig, error) {
+	var config shimmerdata.SDBatchConfig
+	if *f.configPath != "" {
+		c, err := shimmerdata.LoadConfig(*f.configPath)
+		if err != nil {
+			return config, err
+		}
+		config, err = c.BatchConfig()
+		if err != nil {
+			return config, err
+		}
+	}
+	if *f.serverUrl != "" {
+		config.ServerUrl = *f.serverUrl
+	}
+	if *f.appId != "" {
+		config.AppId = *f.appId
+	}
+	if *f.appToken != "" {
+		config.AppToken = *f.appToken
+	}
+	if *f.protocol != 0 {
+		config.Protocol = shimmerdata.ProtocolVersion(*f.protocol)
+	}
+	if *f.sign {
+		config.Sign = true
+	}
+	if config.ServerUrl == "" || config.AppId == "" || config.AppToken == "" {
+		return config, errors.New("url, app and token are required, set them by flags or -config")
+	}
+	return config, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeLayout)
+}
+
+func spoolLs(args []string) error {
+	fs := newFlagSet("spool ls", "<dir|file>...")
+	paths, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	files, err := collect(paths)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tEVENTS\tSTART\tEND\tMODIFIED")
+	var size, events int64
+	for _, f := range files {
+		name := f.Name
+		if f.Active {
+			//正在写入的文件，consumer运行时内容还会变化
+			name += " (active)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", name, f.Size, f.Events,
+			formatTime(f.Start), formatTime(f.End), f.ModTime.Format(timeLayout))
+		size += f.Size
+		events += f.Events
+	}
+	fmt.Fprintf(w, "total %d files\t%d\t%d\t\t\t\n", len(files), size, events)
+	return w.Flush()
+}
+
+func spoolCat(args []string) error {
+	fs := newFlagSet("spool cat", "<dir|file>...")
+	raw := fs.Bool("raw", false, "print events as stored, one JSON per line")
+	paths, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	files, err := collect(paths)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var buf bytes.Buffer
+	for _, f := range files {
+		err = shimmerdata.ReadSpool(f.Path, func(_ int, line []byte) error {
+			buf.Reset()
+			if *raw || json.Indent(&buf, line, "", "  ") != nil {
+				//无法解析的行原样输出
+				buf.Reset()
+				buf.Write(line)
+			}
+			buf.WriteByte('\n')
+			_, err := w.Write(buf.Bytes())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+func spoolReplay(args []string) error {
+	fs := newFlagSet("spool replay", "<dir|file>...")
+	batch := addBatchFlags(fs)
+	remove := fs.Bool("remove", false, "remove files after they are uploaded")
+	active := fs.Bool("active", false, "also upload the active <app>-logback.log, only when no consumer is running")
+	paths, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	config, err := batch.config()
+	if err != nil {
+		return err
+	}
+
+	files, err := collect(paths)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Active && !*active {
+			fmt.Printf("skip %s: active file\n", f.Name)
+			continue
+		}
+		final, err := shimmerdata.ReplaySpool(config, f.Path)
+		if err != nil {
+			return fmt.Errorf("replay %s: %w", f.Path, err)
+		}
+		fmt.Printf("replayed %s: %d events\n", f.Name, f.Events)
+		if *remove {
+			err = os.Remove(final)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func spoolPurge(args []string) error {
+	fs := newFlagSet("spool purge", "<dir|file>...")
+	olderThan := fs.Duration("older-than", 0, "only files modified before this duration ago, e.g. 72h")
+	appId := fs.String("app", "", "only files of this app id")
+	pattern := fs.String("name", "", "only files whose name matches this glob pattern")
+	active := fs.Bool("active", false, "also delete the active <app>-logback.log, only when no consumer is running")
+	all := fs.Bool("all", false, "delete all files when no other filter is set")
+	dryRun := fs.Bool("dry-run", false, "print files to delete without deleting them")
+	paths, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *olderThan <= 0 && *appId == "" && *pattern == "" && !*all {
+		return errors.New("at least one of -older-than, -app, -name or -all is required")
+	}
+	if *pattern != "" {
+		if _, err = filepath.Match(*pattern, ""); err != nil {
+			return fmt.Errorf("invalid -name pattern: %w", err)
+		}
+	}
+
+	files, err := collect(paths)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-*olderThan)
+	for _, f := range files {
+		if f.Active && !*active {
+			continue
+		}
+		if *olderThan > 0 && !f.ModTime.Before(deadline) {
+			continue
+		}
+		if *appId != "" && !strings.HasPrefix(f.Name, *appId+"-logback") {
+			continue
+		}
+		if *pattern != "" {
+			if ok, _ := filepath.Match(*pattern, f.Name); !ok {
+				continue
+			}
+		}
+		if *dryRun {
+			fmt.Printf("would remove %s: %d events\n", f.Name, f.Events)
+			continue
+		}
+		err = os.Remove(f.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %s: %d events\n", f.Name, f.Events)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ShimmerGames-Co-Ltd/shimmerdata-go/shimmerdata"
+)
+
+// validateStats 校验结果统计
+type validateStats struct {
+	files   int
+	events  int
+	invalid int
+	errors  int
+	types   map[string]int // #type -> 条数，包括无效的日志
+}
+
+func runValidate(args []string) error {
+	fs := newFlagSet("validate", "<dir|file>...")
+	maxErrors := fs.Int("max-errors", 100, "stop printing errors after this many, 0 means no limit. all lines are still checked")
+	quiet := fs.Bool("q", false, "only print the summary")
+	paths, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	files, err := expand(paths)
+	if err != nil {
+		return err
+	}
+
+	stats := validateStats{types: make(map[string]int)}
+	for _, file := range files {
+		stats.files++
+		err = shimmerdata.ReadSpool(file, func(n int, line []byte) error {
+			stats.events++
+			d, errs := shimmerdata.ValidateEvent(line)
+			if d != nil {
+				stats.types[d.Type]++
+			}
+			if len(errs) == 0 {
+				return nil
+			}
+			stats.invalid++
+			for _, e := range errs {
+				stats.errors++
+				if !*quiet && (*maxErrors <= 0 || stats.errors <= *maxErrors) {
+					fmt.Printf("%s:%d: %v\n", file, n, e)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	if !*quiet && *maxErrors > 0 && stats.errors > *maxErrors {
+		fmt.Printf("... %d more errors\n", stats.errors-*maxErrors)
+	}
+	stats.print()
+	if stats.invalid > 0 {
+		return fmt.Errorf("%d of %d events are invalid", stats.invalid, stats.events)
+	}
+	return nil
+}
+
+func (s validateStats) print() {
+	fmt.Printf("\n%d files, %d events, %d invalid, %d errors\n", s.files, s.events, s.invalid, s.errors)
+	types := make([]string, 0, len(s.types))
+	for t := range s.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range types {
+		name := t
+		if name == "" {
+			name = "(empty)"
+		}
+		fmt.Fprintf(w, "  %s\t%d\n", name, s.types[t])
+	}
+	_ = w.Flush()
+}
+
+// expand 目录展开为其中的日志文件，跳过写入中的.tmp文件、SDLogConsumer生成的.done文件和import的.checkpoint文件
+func expand(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if entry.IsDir() || ext == ".tmp" || ext == ".done" || ext == ".checkpoint" {
+				continue
+			}
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files found")
+	}
+	return files, nil
+}
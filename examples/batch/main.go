@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"time"
@@ -21,7 +22,10 @@ func main() {
 		Interval:  1,
 	})
 	defer func() {
-		closeErr := client.Close()
+		//最多等待30秒，未发送完的日志写入TempDir，下次启动时上传
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		closeErr := client.Shutdown(ctx)
 		if closeErr != nil {
 			slog.Error("close error", "error", closeErr)
 		}
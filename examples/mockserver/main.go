@@ -0,0 +1,192 @@
+// mockserver 模拟日志接收服务，用于本地调试SDK。支持 ProtocolV1/ProtocolV2 以及签名模式。
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/ShimmerGames-Co-Ltd/shimmerdata-go/shimmerdata"
+)
+
+type server struct {
+	tokens   map[string]string // appId -> appToken
+	verifier *shimmerdata.SignatureVerifier
+	batches  map[string]bool // 已接收的批次ID，重复的批次直接返回成功
+	mutex    sync.Mutex
+}
+
+type reportReq struct {
+	App      string `json:"app"`
+	Token    string `json:"token"`
+	Compress bool   `json:"compress"`
+	Codec    string `json:"codec"`
+	BatchId  string `json:"batch_id"`
+	Size     int64  `json:"size"`
+	Log      []byte `json:"log"`
+}
+
+type uploadReq struct {
+	App      string `json:"app"`
+	Token    string `json:"token"`
+	Codec    string `json:"codec"`
+	Filename string `json:"filename"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Total    int64  `json:"total"`
+	Content  []byte `json:"content"`
+}
+
+func main() {
+	addr := flag.String("addr", ":20005", "listen address")
+	app := flag.String("app", "app-id", "app id")
+	token := flag.String("token", "app-token", "app token")
+	flag.Parse()
+
+	s := &server{
+		tokens:   map[string]string{*app: *token},
+		verifier: shimmerdata.NewSignatureVerifier(0),
+		batches:  make(map[string]bool),
+	}
+	http.HandleFunc("/LogServer/log/report", s.reportV1)
+	http.HandleFunc("/LogServer/log/upload", s.uploadV1)
+	http.HandleFunc("/LogServer/v2/log/report", s.reportV2)
+	http.HandleFunc("/LogServer/v2/log/upload", s.uploadV2)
+
+	slog.Info("mock server listening", "addr", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		slog.Error("listen failed", "error", err)
+	}
+}
+
+// auth 签名模式下校验签名，否则校验明文token
+func (s *server) auth(r *http.Request, body []byte, app, token string) bool {
+	expected, ok := s.tokens[app]
+	if !ok {
+		return false
+	}
+	if r.Header.Get(shimmerdata.HeaderSignature) != "" {
+		err := s.verifier.Verify(r, body, expected)
+		if err != nil {
+			slog.Warn("verify signature failed", "app", app, "error", err)
+			return false
+		}
+		return true
+	}
+	return token == expected
+}
+
+func (s *server) reportV1(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req reportReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		reply(w, http.StatusBadRequest, 1, err.Error())
+		return
+	}
+	if !s.auth(r, body, req.App, req.Token) {
+		reply(w, http.StatusUnauthorized, 1, "unauthorized")
+		return
+	}
+	codec := req.Codec
+	if codec == "" {
+		//旧版本SDK没有codec字段，只能根据compress判断
+		codec = string(shimmerdata.CodecNone)
+		if req.Compress {
+			codec = string(shimmerdata.CodecGzip)
+		}
+	}
+	s.handleLog(w, req.App, codec, req.BatchId, req.Log)
+}
+
+func (s *server) reportV2(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	app := r.Header.Get(shimmerdata.HeaderApp)
+	if !s.auth(r, body, app, r.Header.Get(shimmerdata.HeaderToken)) {
+		reply(w, http.StatusUnauthorized, 1, "unauthorized")
+		return
+	}
+	codec := r.Header.Get(shimmerdata.HeaderCodec)
+	if codec == "" {
+		//没有X-SD-Codec时根据Content-Encoding判断，未压缩的请求没有Content-Encoding
+		codec = r.Header.Get("Content-Encoding")
+	}
+	if codec == "" {
+		codec = string(shimmerdata.CodecNone)
+	}
+	s.handleLog(w, app, codec, r.Header.Get(shimmerdata.HeaderBatchId), body)
+}
+
+func (s *server) handleLog(w http.ResponseWriter, app, codec, batchId string, data []byte) {
+	//旧版本SDK和V1协议可能没有批次ID，不做去重
+	s.mutex.Lock()
+	duplicate := batchId != "" && s.batches[batchId]
+	s.mutex.Unlock()
+	if duplicate {
+		slog.Info("drop duplicate batch", "app", app, "batch", batchId)
+		reply(w, http.StatusOK, 0, "")
+		return
+	}
+	reader, err := shimmerdata.NewDecompressReader(bytes.NewReader(data), shimmerdata.CompressCodec(codec))
+	if err != nil {
+		reply(w, http.StatusUnsupportedMediaType, 1, err.Error())
+		return
+	}
+	defer reader.Close()
+	count := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		count++
+	}
+	if err = scanner.Err(); err != nil {
+		reply(w, http.StatusBadRequest, 1, err.Error())
+		return
+	}
+	if batchId != "" {
+		s.mutex.Lock()
+		s.batches[batchId] = true
+		s.mutex.Unlock()
+	}
+	slog.Info("receive log", "app", app, "codec", codec, "batch", batchId, "count", count)
+	reply(w, http.StatusOK, 0, "")
+}
+
+func (s *server) uploadV1(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req uploadReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		reply(w, http.StatusBadRequest, 1, err.Error())
+		return
+	}
+	if !s.auth(r, body, req.App, req.Token) {
+		reply(w, http.StatusUnauthorized, 1, "unauthorized")
+		return
+	}
+	slog.Info("receive file chunk", "app", req.App, "file", req.Filename, "codec", req.Codec,
+		"start", req.Start, "end", req.End, "total", req.Total)
+	reply(w, http.StatusOK, 0, "")
+}
+
+func (s *server) uploadV2(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	app := r.Header.Get(shimmerdata.HeaderApp)
+	if !s.auth(r, body, app, r.Header.Get(shimmerdata.HeaderToken)) {
+		reply(w, http.StatusUnauthorized, 1, "unauthorized")
+		return
+	}
+	slog.Info("receive file chunk", "app", app, "file", r.Header.Get(shimmerdata.HeaderFilename),
+		"codec", r.Header.Get(shimmerdata.HeaderCodec), "start", r.Header.Get(shimmerdata.HeaderStart),
+		"end", r.Header.Get(shimmerdata.HeaderEnd), "total", r.Header.Get(shimmerdata.HeaderTotal))
+	reply(w, http.StatusOK, 0, "")
+}
+
+func reply(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"Code": code, "Msg": msg})
+}
@@ -1,8 +1,13 @@
 module github.com/ShimmerGames-Co-Ltd/shimmerdata-go
 
-go 1.14
+go 1.22
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.18.0
+	github.com/segmentio/kafka-go v0.4.47
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/pierrec/lz4/v4 v4.1.15 // indirect
@@ -0,0 +1,251 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressCodec 数据压缩算法
+type CompressCodec string
+
+const (
+	CodecNone   CompressCodec = "none"   // 不压缩
+	CodecGzip   CompressCodec = "gzip"   // gzip，服务端默认支持
+	CodecZstd   CompressCodec = "zstd"   // zstd，压缩率和速度都优于gzip
+	CodecSnappy CompressCodec = "snappy" // snappy framed 格式，CPU占用最低
+)
+
+// SDCompression 压缩配置
+type SDCompression struct {
+	Codec CompressCodec // 压缩算法
+	Level int           // 压缩等级，0使用算法默认等级。gzip: 1-9; zstd: 1-22; snappy: 1=快速 2=更好 3=最佳
+}
+
+// errUnsupportedCodec 服务端不支持请求中声明的压缩算法
+var errUnsupportedCodec = errors.New("server does not support the compress codec")
+
+var (
+	zstdEncoders      = make(map[int]*zstd.Encoder)
+	zstdEncodersMutex sync.Mutex
+)
+
+func (c SDCompression) validate() error {
+	switch c.Codec {
+	case CodecNone:
+	case CodecGzip:
+		if c.Level < 0 || c.Level > gzip.BestCompression {
+			return fmt.Errorf("gzip compress level should be in [0, %d], 0 means default, got %d", gzip.BestCompression, c.Level)
+		}
+	case CodecZstd:
+		if c.Level < 0 || c.Level > 22 {
+			return fmt.Errorf("zstd compress level should be in [0, 22], 0 means default, got %d", c.Level)
+		}
+	case CodecSnappy:
+		if c.Level < 0 || c.Level > 3 {
+			return fmt.Errorf("snappy compress level should be in [0, 3], 0 means default, got %d", c.Level)
+		}
+	default:
+		return fmt.Errorf("unknown compress codec: %s", c.Codec)
+	}
+	return nil
+}
+
+// Ext 压缩文件的扩展名
+func (c CompressCodec) Ext() string {
+	switch c {
+	case CodecGzip:
+		return ".gz"
+	case CodecZstd:
+		return ".zst"
+	case CodecSnappy:
+		return ".sz"
+	default:
+		return ""
+	}
+}
+
+// codecFromFilename 根据文件扩展名判断压缩算法
+func codecFromFilename(name string) CompressCodec {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		return CodecGzip
+	case ".zst":
+		return CodecZstd
+	case ".sz":
+		return CodecSnappy
+	default:
+		return CodecNone
+	}
+}
+
+// newCompressWriter 创建压缩流，调用方负责Close以写入压缩尾部
+func newCompressWriter(w io.Writer, conf SDCompression) (io.WriteCloser, error) {
+	switch conf.Codec {
+	case CodecGzip:
+		level := gzip.DefaultCompression
+		if conf.Level > 0 {
+			level = conf.Level
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CodecZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(conf.Level)))
+	case CodecSnappy:
+		return s2.NewWriter(w, snappyOptions(conf.Level)...), nil
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown compress codec: %s", conf.Codec)
+	}
+}
+
+// NewDecompressReader 创建解压流，可用于读取缓存文件或服务端解码请求
+func NewDecompressReader(r io.Reader, codec CompressCodec) (io.ReadCloser, error) {
+	switch codec {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CodecSnappy:
+		return io.NopCloser(s2.NewReader(r)), nil
+	case CodecNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown compress codec: %s", codec)
+	}
+}
+
+// encodeData 使用指定算法压缩整块数据
+func encodeData(data []byte, conf SDCompression) ([]byte, error) {
+	if conf.Codec == CodecNone {
+		return data, nil
+	}
+	if conf.Codec == CodecZstd {
+		enc, err := zstdEncoder(conf.Level)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	}
+
+	var buf bytes.Buffer
+	w, err := newCompressWriter(&buf, conf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// zstdEncoder zstd编码器创建开销较大，按等级复用。EncodeAll 可以并发调用
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	zstdEncodersMutex.Lock()
+	defer zstdEncodersMutex.Unlock()
+	if enc, ok := zstdEncoders[level]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel(level)))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[level] = enc
+	return enc, nil
+}
+
+func zstdLevel(level int) zstd.EncoderLevel {
+	if level <= 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(level)
+}
+
+func snappyOptions(level int) []s2.WriterOption {
+	opts := []s2.WriterOption{s2.WriterSnappyCompat()}
+	switch level {
+	case 2:
+		opts = append(opts, s2.WriterBetterCompression())
+	case 3:
+		opts = append(opts, s2.WriterBestCompression())
+	}
+	return opts
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// transcodeFile 将文件按扩展名解压后使用新的算法重新压缩，成功后删除原文件，返回新文件路径
+func transcodeFile(src string, conf SDCompression) (string, error) {
+	srcCodec := codecFromFilename(src)
+	if srcCodec == conf.Codec {
+		return src, nil
+	}
+	dst := strings.TrimSuffix(src, srcCodec.Ext()) + conf.Codec.Ext()
+	return dst, transcodeFileTo(src, dst, conf)
+}
+
+// transcodeFileTo 与transcodeFile相同，但由调用方指定新文件路径
+func transcodeFileTo(src, dst string, conf SDCompression) error {
+	srcCodec := codecFromFilename(src)
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := NewDecompressReader(in, srcCodec)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	//先写入临时文件，避免中途失败留下不完整的文件被上传
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+	w, err := newCompressWriter(out, conf)
+	if err == nil {
+		_, err = io.Copy(w, r)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return err
+	}
+	_ = in.Close()
+	return os.Remove(src)
+}
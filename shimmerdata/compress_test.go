@@ -0,0 +1,80 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeData(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"#type":"track","#event_name":"event_name","properties":{"a":1}}`+"\n"), 100)
+	for _, conf := range []SDCompression{
+		{Codec: CodecNone},
+		{Codec: CodecGzip},
+		{Codec: CodecGzip, Level: 9},
+		{Codec: CodecZstd},
+		{Codec: CodecZstd, Level: 19},
+		{Codec: CodecSnappy},
+		{Codec: CodecSnappy, Level: 3},
+	} {
+		encoded, err := encodeData(data, conf)
+		if err != nil {
+			t.Fatal(conf.Codec, err)
+		}
+		r, err := NewDecompressReader(bytes.NewReader(encoded), conf.Codec)
+		if err != nil {
+			t.Fatal(conf.Codec, err)
+		}
+		decoded, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(conf.Codec, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("%s level %d: decoded data mismatch", conf.Codec, conf.Level)
+		}
+	}
+
+	if err := (SDCompression{Codec: "lz4"}).validate(); err == nil {
+		t.Fatal("unknown codec should be rejected")
+	}
+}
+
+func TestTranscodeFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app-logback-2024-01-01T00-00-00.000.log")
+	data := []byte("{\"a\":1}\n{\"b\":2}\n")
+	if err := os.WriteFile(src, data, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	zst, err := transcodeFile(src, SDCompression{Codec: CodecZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := transcodeFile(zst, SDCompression{Codec: CodecGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(gz) != ".gz" {
+		t.Fatalf("unexpected file name: %s", gz)
+	}
+	if _, err = os.Stat(zst); !os.IsNotExist(err) {
+		t.Fatal("source file should be removed")
+	}
+
+	f, err := os.Open(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewDecompressReader(f, codecFromFilename(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := io.ReadAll(r)
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("decoded data mismatch")
+	}
+}
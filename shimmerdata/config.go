@@ -0,0 +1,571 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConsumerType type of the consumer created from Config
+type ConsumerType string
+
+const (
+	ConsumerBatch  ConsumerType = "batch"  // SDBatchConsumer
+	ConsumerLog    ConsumerType = "log"    // SDLogConsumer
+	ConsumerKafka  ConsumerType = "kafka"  // SDKafkaConsumer
+	ConsumerWriter ConsumerType = "writer" // SDWriterConsumer writing to stdout or stderr
+	ConsumerMulti  ConsumerType = "multi"  // SDMultiConsumer of Config.Consumers
+	ConsumerDebug  ConsumerType = "debug"  // stringent and unbuffered SDWriterConsumer writing to stdout
+)
+
+// EnvPrefix prefix of the environment variables read by LoadConfig.
+// the variable of a field is the upper case path of its name, such as SHIMMERDATA_BATCH_SERVER_URL.
+const EnvPrefix = "SHIMMERDATA_"
+
+// Config configuration of a consumer, usually loaded by LoadConfig.
+// only the section of Type is used.
+type Config struct {
+	Type      ConsumerType  `json:"type" yaml:"type"`
+	LogLevel  string        `json:"log_level" yaml:"log_level"` // off, error, warning, info or debug. empty means the SetLogLevel default
+	Batch     BatchSection  `json:"batch" yaml:"batch"`
+	Log       LogSection    `json:"log" yaml:"log"`
+	Kafka     KafkaSection  `json:"kafka" yaml:"kafka"`
+	Writer    WriterSection `json:"writer" yaml:"writer"`
+	Consumers []Config      `json:"consumers" yaml:"consumers"` // consumers of multi type, not read from environment
+}
+
+// BatchSection options of SDBatchConfig
+type BatchSection struct {
+	ServerUrl   string             `json:"server_url" yaml:"server_url"`
+	AppId       string             `json:"app_id" yaml:"app_id"`
+	AppToken    string             `json:"app_token" yaml:"app_token"`
+	TempDir     string             `json:"temp_dir" yaml:"temp_dir"`
+	BatchSize   int                `json:"batch_size" yaml:"batch_size"`
+	Interval    int                `json:"interval" yaml:"interval"` // seconds
+	Timeout     Duration           `json:"timeout" yaml:"timeout"`
+	Compress    bool               `json:"compress" yaml:"compress"`
+	Compression CompressionSection `json:"compression" yaml:"compression"`
+	Protocol    int                `json:"protocol" yaml:"protocol"`
+	Sign        bool               `json:"sign" yaml:"sign"`
+	DedupeSize  int                `json:"dedupe_size" yaml:"dedupe_size"`
+}
+
+// LogSection options of SDLogConsumerConfig
+type LogSection struct {
+	Directory      string   `json:"directory" yaml:"directory"`
+	RotateMode     string   `json:"rotate_mode" yaml:"rotate_mode"` // daily or hourly, default daily
+	FileSize       int      `json:"file_size" yaml:"file_size"`     // MByte
+	FileNamePrefix string   `json:"file_name_prefix" yaml:"file_name_prefix"`
+	ChannelSize    int      `json:"channel_size" yaml:"channel_size"`
+	MaxFiles       int      `json:"max_files" yaml:"max_files"`
+	MaxAge         Duration `json:"max_age" yaml:"max_age"`
+	Compress       bool     `json:"compress" yaml:"compress"`
+	AtomicRename   bool     `json:"atomic_rename" yaml:"atomic_rename"`
+	DoneManifest   bool     `json:"done_manifest" yaml:"done_manifest"`
+	BufferSize     int      `json:"buffer_size" yaml:"buffer_size"`
+	FlushInterval  Duration `json:"flush_interval" yaml:"flush_interval"`
+	SyncPolicy     string   `json:"sync_policy" yaml:"sync_policy"` // never, always, interval or events, default never
+	SyncInterval   Duration `json:"sync_interval" yaml:"sync_interval"`
+	SyncEvents     int      `json:"sync_events" yaml:"sync_events"`
+	FullPolicy     string   `json:"full_policy" yaml:"full_policy"` // block, timeout or drop, default block
+	EnqueueTimeout Duration `json:"enqueue_timeout" yaml:"enqueue_timeout"`
+}
+
+// KafkaSection options of SDKafkaConfig
+type KafkaSection struct {
+	Brokers     []string           `json:"brokers" yaml:"brokers"` // comma separated in environment
+	Topic       string             `json:"topic" yaml:"topic"`
+	KeyField    string             `json:"key_field" yaml:"key_field"` // distinct_id or account_id, default distinct_id
+	BatchSize   int                `json:"batch_size" yaml:"batch_size"`
+	Interval    int                `json:"interval" yaml:"interval"` // seconds
+	Timeout     Duration           `json:"timeout" yaml:"timeout"`
+	ChannelSize int                `json:"channel_size" yaml:"channel_size"`
+	Compression CompressionSection `json:"compression" yaml:"compression"`
+}
+
+// WriterSection options of SDWriterConsumerConfig
+type WriterSection struct {
+	Output         string   `json:"output" yaml:"output"` // stdout or stderr, default stdout
+	ChannelSize    int      `json:"channel_size" yaml:"channel_size"`
+	BufferSize     int      `json:"buffer_size" yaml:"buffer_size"`
+	FlushInterval  Duration `json:"flush_interval" yaml:"flush_interval"`
+	FullPolicy     string   `json:"full_policy" yaml:"full_policy"` // block, timeout or drop, default block
+	EnqueueTimeout Duration `json:"enqueue_timeout" yaml:"enqueue_timeout"`
+}
+
+// CompressionSection options of SDCompression
+type CompressionSection struct {
+	Codec string `json:"codec" yaml:"codec"` // none, gzip, zstd or snappy
+	Level int    `json:"level" yaml:"level"`
+}
+
+// Duration time.Duration written as a string such as "30s" or "1h30m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfig read config from a JSON (".json") or YAML (".yaml", ".yml") file, then override it with
+// SHIMMERDATA_* environment variables. the file is optional if path is empty. the result is validated.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path != "" {
+		err := decodeFile(path, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := config.loadEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeFile decode a JSON (".json") or YAML (".yaml", ".yml") file into v, unknown fields are rejected
+func decodeFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(v)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(v)
+	default:
+		return fmt.Errorf("unknown file format: %s, should be .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse %s failed: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv override fields with environment variables
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	loadEnvFields(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup, &errs)
+	return errors.Join(errs...)
+}
+
+func loadEnvFields(v reflect.Value, prefix string, lookup func(string) (string, bool), errs *[]error) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		key := prefix + "_" + strings.ToUpper(name)
+		fv := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			loadEnvFields(fv, key, lookup, errs)
+			continue
+		}
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		err := setField(fv, value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+}
+
+func setField(v reflect.Value, value string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			// consumers of multi type can only be set in the file
+			return errors.New("can not be set by environment")
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("can not be set by environment")
+	}
+	return nil
+}
+
+// Validate check all options of the selected consumer type, every problem is reported with its path
+func (c *Config) Validate() error {
+	return errors.Join(c.validate("")...)
+}
+
+func (c *Config) validate(path string) []error {
+	var errs []error
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, pathError(path, "log_level", err))
+	}
+	switch c.Type {
+	case ConsumerBatch:
+		_, batchErrs := c.Batch.config(path + "batch.")
+		errs = append(errs, batchErrs...)
+	case ConsumerLog:
+		_, logErrs := c.Log.config(path + "log.")
+		errs = append(errs, logErrs...)
+	case ConsumerKafka:
+		_, kafkaErrs := c.Kafka.config(path + "kafka.")
+		errs = append(errs, kafkaErrs...)
+	case ConsumerWriter:
+		_, _, writerErrs := c.Writer.config(path + "writer.")
+		errs = append(errs, writerErrs...)
+	case ConsumerDebug:
+	case ConsumerMulti:
+		if len(c.Consumers) == 0 {
+			errs = append(errs, pathError(path, "consumers", errors.New("at least one consumer is required for multi type")))
+		}
+		for i := range c.Consumers {
+			errs = append(errs, c.Consumers[i].validate(fmt.Sprintf("%sconsumers[%d].", path, i))...)
+		}
+	case "":
+		errs = append(errs, pathError(path, "type", errors.New("can not be empty")))
+	default:
+		errs = append(errs, pathError(path, "type", fmt.Errorf("unknown consumer type %q, should be batch, log, kafka, writer, multi or debug", c.Type)))
+	}
+	return errs
+}
+
+// Options options of SDAnalytics and consumers derived from the config, such as the log level
+func (c *Config) Options() []Option {
+	level, _ := parseLogLevel(c.LogLevel)
+	if level == 0 {
+		return nil
+	}
+	return []Option{WithLogLevel(level)}
+}
+
+// NewConsumer create the consumer described by the config. opts are applied after Options of the config.
+func (c *Config) NewConsumer(opts ...Option) (SDConsumer, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(c.Options(), opts...)
+	switch c.Type {
+	case ConsumerBatch:
+		config, _ := c.Batch.config("")
+		return NewBatchConsumer(config, opts...)
+	case ConsumerLog:
+		config, _ := c.Log.config("")
+		return NewLogConsumerWithConfig(config, opts...)
+	case ConsumerKafka:
+		config, _ := c.Kafka.config("")
+		return NewKafkaConsumer(config, opts...)
+	case ConsumerWriter:
+		w, config, _ := c.Writer.config("")
+		return NewWriterConsumer(w, config, opts...)
+	case ConsumerDebug:
+		return NewWriterConsumer(os.Stdout, SDWriterConsumerConfig{BufferSize: -1, Stringent: true}, opts...)
+	default:
+		consumers := make([]SDConsumer, 0, len(c.Consumers))
+		for i := range c.Consumers {
+			consumer, err := c.Consumers[i].NewConsumer(opts...)
+			if err != nil {
+				for _, created := range consumers {
+					_ = created.Close()
+				}
+				return nil, fmt.Errorf("consumers[%d]: %w", i, err)
+			}
+			consumers = append(consumers, consumer)
+		}
+		return NewMultiConsumer(consumers...)
+	}
+}
+
+// BatchConfig the SDBatchConfig of a batch config, or of the first batch consumer of a multi config.
+// tools working on the TempDir spool use it to find the collector and credentials.
+func (c *Config) BatchConfig() (SDBatchConfig, error) {
+	switch c.Type {
+	case ConsumerBatch:
+		config, errs := c.Batch.config("batch.")
+		return config, errors.Join(errs...)
+	case ConsumerMulti:
+		for i := range c.Consumers {
+			if c.Consumers[i].Type == ConsumerBatch {
+				config, errs := c.Consumers[i].Batch.config(fmt.Sprintf("consumers[%d].batch.", i))
+				return config, errors.Join(errs...)
+			}
+		}
+	}
+	return SDBatchConfig{}, errors.New("no batch consumer in config")
+}
+
+func pathError(path, name string, err error) error {
+	return fmt.Errorf("%s%s: %w", path, name, err)
+}
+
+func parseLogLevel(s string) (SDLogLevel, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "off":
+		return SDLogLevelOff, nil
+	case "error":
+		return SDLogLevelError, nil
+	case "warning", "warn":
+		return SDLogLevelWarning, nil
+	case "info":
+		return SDLogLevelInfo, nil
+	case "debug":
+		return SDLogLevelDebug, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, should be off, error, warning, info or debug", s)
+	}
+}
+
+func (s CompressionSection) config() (SDCompression, error) {
+	if s.Codec == "" {
+		return SDCompression{}, nil
+	}
+	compression := SDCompression{Codec: CompressCodec(strings.ToLower(s.Codec)), Level: s.Level}
+	return compression, compression.validate()
+}
+
+func (s BatchSection) config(path string) (SDBatchConfig, []error) {
+	var errs []error
+	if s.ServerUrl == "" {
+		errs = append(errs, pathError(path, "server_url", errors.New("can not be empty")))
+	} else if !strings.HasPrefix(s.ServerUrl, "http://") && !strings.HasPrefix(s.ServerUrl, "https://") {
+		errs = append(errs, pathError(path, "server_url", fmt.Errorf("%q should start with http:// or https://", s.ServerUrl)))
+	}
+	if s.AppId == "" {
+		errs = append(errs, pathError(path, "app_id", errors.New("can not be empty")))
+	}
+	if s.AppToken == "" {
+		errs = append(errs, pathError(path, "app_token", errors.New("can not be empty")))
+	}
+	if s.BatchSize < 0 || s.BatchSize > MaxBatchSize {
+		errs = append(errs, pathError(path, "batch_size", fmt.Errorf("should be in [1, %d], got %d", MaxBatchSize, s.BatchSize)))
+	}
+	if s.Interval < 0 {
+		errs = append(errs, pathError(path, "interval", errors.New("can not be negative")))
+	}
+	if s.Timeout < 0 {
+		errs = append(errs, pathError(path, "timeout", errors.New("can not be negative")))
+	}
+	if s.DedupeSize < 0 {
+		errs = append(errs, pathError(path, "dedupe_size", errors.New("can not be negative")))
+	}
+	if s.Protocol != 0 {
+		if err := ProtocolVersion(s.Protocol).validate(); err != nil {
+			errs = append(errs, pathError(path, "protocol", err))
+		}
+	}
+	compression, err := s.Compression.config()
+	if err != nil {
+		errs = append(errs, pathError(path, "compression", err))
+	}
+	return SDBatchConfig{
+		TempDir:     s.TempDir,
+		ServerUrl:   s.ServerUrl,
+		AppId:       s.AppId,
+		AppToken:    s.AppToken,
+		BatchSize:   s.BatchSize,
+		Timeout:     time.Duration(s.Timeout),
+		Compress:    s.Compress,
+		Interval:    s.Interval,
+		Compression: compression,
+		Protocol:    ProtocolVersion(s.Protocol),
+		Sign:        s.Sign,
+		DedupeSize:  s.DedupeSize,
+	}, errs
+}
+
+func (s LogSection) config(path string) (SDLogConsumerConfig, []error) {
+	var errs []error
+	if s.Directory == "" {
+		errs = append(errs, pathError(path, "directory", errors.New("can not be empty")))
+	}
+	var rotateMode RotateMode
+	switch strings.ToLower(s.RotateMode) {
+	case "", "daily":
+		rotateMode = RotateDaily
+	case "hourly":
+		rotateMode = RotateHourly
+	default:
+		errs = append(errs, pathError(path, "rotate_mode", fmt.Errorf("unknown rotate mode %q, should be daily or hourly", s.RotateMode)))
+	}
+	if s.FileSize < 0 {
+		errs = append(errs, pathError(path, "file_size", errors.New("can not be negative")))
+	}
+	if s.MaxFiles < 0 {
+		errs = append(errs, pathError(path, "max_files", errors.New("can not be negative")))
+	}
+	if s.MaxAge < 0 {
+		errs = append(errs, pathError(path, "max_age", errors.New("can not be negative")))
+	}
+	var syncPolicy SyncPolicy
+	switch strings.ToLower(s.SyncPolicy) {
+	case "", "never":
+		syncPolicy = SyncNever
+	case "always":
+		syncPolicy = SyncAlways
+	case "interval":
+		syncPolicy = SyncInterval
+		if s.SyncInterval <= 0 {
+			errs = append(errs, pathError(path, "sync_interval", errors.New("must be positive for interval sync policy")))
+		}
+	case "events":
+		syncPolicy = SyncEvents
+		if s.SyncEvents <= 0 {
+			errs = append(errs, pathError(path, "sync_events", errors.New("must be positive for events sync policy")))
+		}
+	default:
+		errs = append(errs, pathError(path, "sync_policy", fmt.Errorf("unknown sync policy %q, should be never, always, interval or events", s.SyncPolicy)))
+	}
+	fullPolicy, err := parseFullPolicy(s.FullPolicy, s.EnqueueTimeout)
+	if err != nil {
+		errs = append(errs, pathError(path, "full_policy", err))
+	}
+	return SDLogConsumerConfig{
+		Directory:      s.Directory,
+		RotateMode:     rotateMode,
+		FileSize:       s.FileSize,
+		FileNamePrefix: s.FileNamePrefix,
+		ChannelSize:    s.ChannelSize,
+		MaxFiles:       s.MaxFiles,
+		MaxAge:         time.Duration(s.MaxAge),
+		Compress:       s.Compress,
+		AtomicRename:   s.AtomicRename,
+		DoneManifest:   s.DoneManifest,
+		BufferSize:     s.BufferSize,
+		FlushInterval:  time.Duration(s.FlushInterval),
+		SyncPolicy:     syncPolicy,
+		SyncInterval:   time.Duration(s.SyncInterval),
+		SyncEvents:     s.SyncEvents,
+		FullPolicy:     fullPolicy,
+		EnqueueTimeout: time.Duration(s.EnqueueTimeout),
+	}, errs
+}
+
+func (s KafkaSection) config(path string) (SDKafkaConfig, []error) {
+	var errs []error
+	if len(s.Brokers) == 0 {
+		errs = append(errs, pathError(path, "brokers", errors.New("can not be empty")))
+	}
+	if s.Topic == "" {
+		errs = append(errs, pathError(path, "topic", errors.New("can not be empty")))
+	}
+	var keyField KafkaKeyField
+	switch strings.ToLower(s.KeyField) {
+	case "", "distinct_id":
+		keyField = KafkaKeyDistinctId
+	case "account_id":
+		keyField = KafkaKeyAccountId
+	default:
+		errs = append(errs, pathError(path, "key_field", fmt.Errorf("unknown key field %q, should be distinct_id or account_id", s.KeyField)))
+	}
+	if s.BatchSize < 0 {
+		errs = append(errs, pathError(path, "batch_size", errors.New("can not be negative")))
+	}
+	if s.Interval < 0 {
+		errs = append(errs, pathError(path, "interval", errors.New("can not be negative")))
+	}
+	if s.Timeout < 0 {
+		errs = append(errs, pathError(path, "timeout", errors.New("can not be negative")))
+	}
+	compression, err := s.Compression.config()
+	if err != nil {
+		errs = append(errs, pathError(path, "compression", err))
+	}
+	return SDKafkaConfig{
+		Brokers:     s.Brokers,
+		Topic:       s.Topic,
+		KeyField:    keyField,
+		BatchSize:   s.BatchSize,
+		Interval:    s.Interval,
+		Timeout:     time.Duration(s.Timeout),
+		ChannelSize: s.ChannelSize,
+		Compression: compression,
+	}, errs
+}
+
+func (s WriterSection) config(path string) (*os.File, SDWriterConsumerConfig, []error) {
+	var errs []error
+	var w *os.File
+	switch strings.ToLower(s.Output) {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		errs = append(errs, pathError(path, "output", fmt.Errorf("unknown output %q, should be stdout or stderr", s.Output)))
+	}
+	fullPolicy, err := parseFullPolicy(s.FullPolicy, s.EnqueueTimeout)
+	if err != nil {
+		errs = append(errs, pathError(path, "full_policy", err))
+	}
+	return w, SDWriterConsumerConfig{
+		ChannelSize:    s.ChannelSize,
+		BufferSize:     s.BufferSize,
+		FlushInterval:  time.Duration(s.FlushInterval),
+		FullPolicy:     fullPolicy,
+		EnqueueTimeout: time.Duration(s.EnqueueTimeout),
+	}, errs
+}
+
+func parseFullPolicy(s string, timeout Duration) (ChannelFullPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "block":
+		return ChannelFullBlock, nil
+	case "timeout":
+		if timeout <= 0 {
+			return ChannelFullTimeout, errors.New("enqueue_timeout must be positive for timeout policy")
+		}
+		return ChannelFullTimeout, nil
+	case "drop":
+		return ChannelFullDrop, nil
+	default:
+		return ChannelFullBlock, fmt.Errorf("unknown channel full policy %q, should be block, timeout or drop", s)
+	}
+}
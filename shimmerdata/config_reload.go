@@ -0,0 +1,111 @@
+package shimmerdata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ApplyConfig apply config to the running SDK without restarting it. the consumer must have been created
+// from a config of the same type. the log level of the SDK and consumers is always applied, the batch section
+// is applied by SDBatchConsumer.UpdateConfig, changes of other sections take effect after restart.
+func (ta *SDAnalytics) ApplyConfig(config *Config) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	level, _ := parseLogLevel(config.LogLevel)
+	if level != 0 {
+		ta.SetLogLevel(level)
+	}
+	return config.applyTo(ta.consumer)
+}
+
+func (c *Config) applyTo(consumer SDConsumer) error {
+	if !c.sameType(consumer) {
+		return fmt.Errorf("consumer type can not be changed at runtime, current consumer is %T", consumer)
+	}
+	level, _ := parseLogLevel(c.LogLevel)
+	if l, ok := consumer.(interface{ SetLogLevel(SDLogLevel) }); ok && level != 0 {
+		l.SetLogLevel(level)
+	}
+	switch c.Type {
+	case ConsumerBatch:
+		config, _ := c.Batch.config("")
+		return consumer.(*SDBatchConsumer).UpdateConfig(config)
+	case ConsumerMulti:
+		multi := consumer.(*SDMultiConsumer)
+		if len(multi.consumers) != len(c.Consumers) {
+			return errors.New("number of consumers can not be changed at runtime")
+		}
+		var errs []error
+		for i := range c.Consumers {
+			err := c.Consumers[i].applyTo(multi.consumers[i])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("consumers[%d]: %w", i, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (c *Config) sameType(consumer SDConsumer) bool {
+	switch consumer.(type) {
+	case *SDBatchConsumer:
+		return c.Type == ConsumerBatch
+	case *SDLogConsumer:
+		return c.Type == ConsumerLog
+	case *SDKafkaConsumer:
+		return c.Type == ConsumerKafka
+	case *SDWriterConsumer:
+		return c.Type == ConsumerWriter || c.Type == ConsumerDebug
+	case *SDMultiConsumer:
+		return c.Type == ConsumerMulti
+	}
+	return false
+}
+
+// WatchConfig check the config file every interval, reload it by LoadConfig and apply it by ApplyConfig
+// after it is modified. it blocks until ctx is done, errors of reloading are logged and the old config is kept.
+func (ta *SDAnalytics) WatchConfig(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	modTime := stat.ModTime()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			stat, err = os.Stat(path)
+			if err != nil {
+				ta.log.Error("stat config file failed", "file", path, "error", err)
+				continue
+			}
+			if stat.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = stat.ModTime()
+			config, err := LoadConfig(path)
+			if err != nil {
+				ta.log.Error("reload config failed", "file", path, "error", err)
+				continue
+			}
+			err = ta.ApplyConfig(config)
+			if err != nil {
+				ta.log.Error("apply config failed", "file", path, "error", err)
+				continue
+			}
+			ta.log.Info("config reloaded", "file", path)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package shimmerdata
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shimmerdata.yaml")
+	err := os.WriteFile(path, []byte(`
+type: multi
+log_level: error
+consumers:
+  - type: log
+    log:
+      directory: `+dir+`
+      rotate_mode: hourly
+      max_age: 72h
+      sync_policy: interval
+      sync_interval: 5s
+  - type: batch
+    batch:
+      server_url: http://localhost:20005
+      app_id: app-id
+      compression:
+        codec: zstd
+`), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHIMMERDATA_LOG_LEVEL", "debug")
+
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "consumers[1].batch.app_token: can not be empty") {
+		t.Fatalf("got %v, want app_token error", err)
+	}
+
+	err = os.WriteFile(path, []byte(`
+type: log
+log:
+  directory: `+dir+`
+  rotate_mode: hourly
+  max_age: 72h
+  sync_policy: interval
+  sync_interval: 5s
+`), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHIMMERDATA_LOG_FILE_NAME_PREFIX", "game")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LogLevel != "debug" || config.Log.FileNamePrefix != "game" || time.Duration(config.Log.MaxAge) != 72*time.Hour {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	c, err := config.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c, config.Options()...)
+	err = client.Track("", "player-1", "event_name", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "game.log.*"))
+	if len(files) != 1 {
+		t.Fatalf("got files %v, want one log file", files)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("SHIMMERDATA_TYPE", "kafka")
+	t.Setenv("SHIMMERDATA_KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092")
+	t.Setenv("SHIMMERDATA_KAFKA_TOPIC", "events")
+	t.Setenv("SHIMMERDATA_KAFKA_TIMEOUT", "10s")
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Kafka.Brokers) != 2 || config.Kafka.Brokers[1] != "kafka-2:9092" || time.Duration(config.Kafka.Timeout) != 10*time.Second {
+		t.Fatalf("unexpected config: %+v", config.Kafka)
+	}
+
+	t.Setenv("SHIMMERDATA_KAFKA_TIMEOUT", "ten seconds")
+	_, err = LoadConfig("")
+	if err == nil || !strings.Contains(err.Error(), "SHIMMERDATA_KAFKA_TIMEOUT") {
+		t.Fatalf("got %v, want timeout error", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	config := Config{
+		Type:     ConsumerLog,
+		LogLevel: "verbose",
+		Log: LogSection{
+			RotateMode: "weekly",
+			FullPolicy: "timeout",
+		},
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("invalid config should fail")
+	}
+	for _, want := range []string{"log_level:", "log.directory:", "log.rotate_mode:", "log.full_policy:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q is not reported in %v", want, err)
+		}
+	}
+}
@@ -2,15 +2,15 @@ package shimmerdata
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,21 +19,37 @@ import (
 
 // SDBatchConsumer 通过HTTP协议上报日志
 type SDBatchConsumer struct {
-	conf            SDBatchConfig //启动配置
-	logPrinter      *printer      //日志打印
-	count           int64         //统计总数
-	countSend       int64         //统计发送总数
-	ticker          *time.Ticker  //定时器
-	buffer          *SafeList     //日志缓存
-	listener        chan *Data    //日志通道
-	watchFlushForce atomic.Int64  //强制发送信号监听
-	watchFlush      atomic.Int64  //非强制发送信号监听
-	watchStop       chan struct{} //发送进程退出
-	stopped         chan struct{} //关闭信号
-	dirWatchStop    chan struct{} //文件监听关闭信号
-	dirWatchStopped chan struct{} //文件监听关闭信号
+	conf            atomic.Pointer[SDBatchConfig] //当前配置，可以通过UpdateConfig修改
+	logPrinter      *printer                      //日志打印
+	count           int64                         //统计总数
+	countSend       int64                         //统计发送总数
+	countDeduped    int64                         //统计被去重丢弃的总数
+	acked           *uuidFilter                   //最近发送成功的#uuid，为空时不去重
+	ticker          *time.Ticker                  //定时器
+	buffer          *SafeList                     //日志缓存
+	listener        chan *Data                    //日志通道
+	drainRequest    chan chan error               //Drain请求，由listen转发给发送进程
+	drainFlush      chan chan error               //发送进程执行Drain，返回是否有日志丢失
+	watchFlushForce atomic.Int64                  //强制发送信号监听
+	watchFlush      atomic.Int64                  //非强制发送信号监听
+	watchStop       chan struct{}                 //发送进程退出
+	stopped         chan struct{}                 //关闭信号
+	dirWatchStop    chan struct{}                 //文件监听关闭信号
+	dirWatchStopped chan struct{}                 //文件监听关闭信号
+	compression     atomic.Pointer[SDCompression] //当前使用的压缩配置，服务端不支持时会降级为gzip
+	closed          bool                          //是否已关闭
+	closeMutex      sync.RWMutex                  //关闭listener时阻止新的写入
+	updateMutex     sync.Mutex                    //串行执行UpdateConfig
+	shutdownOnce    sync.Once                     //保证只关闭一次
+	shutdownDone    chan struct{}                 //关闭完成信号
+	abortCtx        context.Context               //关闭超时后取消，中断正在进行的发送和上传
+	abortCancel     context.CancelFunc            //取消abortCtx
+	instanceLog                                   //内部日志
 }
 
+// ErrConsumerClosed consumer关闭后继续写入日志时返回
+var ErrConsumerClosed = errors.New("add event failed, SDK has been closed")
+
 // SDBatchConfig 启动配置参数
 type SDBatchConfig struct {
 	TempDir   string        // 用于日志无法正常发送到HTTP服务器时缓存日志的本地目录，如果为空则丢弃无法发送的日志
@@ -42,16 +58,23 @@ type SDBatchConfig struct {
 	AppToken  string        // appToken 向日志接收服务器注册后获得
 	BatchSize int           // 一次打包传输的对象个数
 	Timeout   time.Duration // http 请求超时时间
-	Compress  bool          // 是否允许使用gzip压缩http数据
+	Compress  bool          // 是否允许使用gzip压缩http数据，Compression 未设置时生效
 	Interval  int           // 自动发送间隔时间 (秒)
+
+	Compression SDCompression   // 压缩算法和等级，同时用于HTTP发送和本地缓存文件
+	Protocol    ProtocolVersion // 传输协议版本，默认ProtocolV1。ProtocolV2 直接发送二进制数据，需要服务端支持
+	Sign        bool            // 使用appToken对请求做HMAC-SHA256签名，appToken不再随请求发送
+	DedupeSize  int             // 客户端去重：记录最近发送成功的#uuid个数，相同#uuid的日志再次写入时丢弃。0表示不开启。只检查Add写入的日志，缓存文件重新发送时依靠批次ID由服务端去重
 }
 
 type request struct {
 	App      string `json:"app"`
-	Token    string `json:"token"`
+	Token    string `json:"token,omitempty"`
 	SDK      string `json:"sdk"`
 	Version  string `json:"version"`
 	Compress bool   `json:"compress"`
+	Codec    string `json:"codec,omitempty"`
+	BatchId  string `json:"batch_id"` //批次ID，重试时不变，用于服务端去重
 	Size     int64  `json:"size"`
 	Log      []byte `json:"log"`
 }
@@ -63,91 +86,96 @@ const (
 	DefaultInterval  = 30
 )
 
-func NewBatchConsumer(config SDBatchConfig) (SDConsumer, error) {
+func NewBatchConsumer(config SDBatchConfig, opts ...Option) (SDConsumer, error) {
+	o := newOptions(opts)
 	if config.ServerUrl == "" {
 		msg := fmt.Sprint("ServerUrl can not be empty")
-		sdLogInfo(msg)
+		o.logger.Info(msg)
 		return nil, errors.New(msg)
 	}
 
-	var batchSize int
-	if config.BatchSize > MaxBatchSize {
-		batchSize = MaxBatchSize
-	} else if config.BatchSize <= 0 {
-		batchSize = DefaultBatchSize
-	} else {
-		batchSize = config.BatchSize
-	}
-	if config.Timeout <= 0 {
-		config.Timeout = time.Duration(DefaultTimeOut) * time.Millisecond
+	compression, err := config.normalize()
+	if err != nil {
+		o.logger.Info(err.Error())
+		return nil, err
 	}
-	var interval int
-	if config.Interval == 0 {
-		interval = DefaultInterval
-	} else {
-		interval = config.Interval
+	if config.TempDir != "" {
+		abs, err := checkAndMakeFolder(config.TempDir)
+		if err != nil {
+			return nil, err
+		}
+		config.TempDir = abs
 	}
 	c := &SDBatchConsumer{
-		conf:            config,
-		ticker:          time.NewTicker(time.Duration(interval) * time.Second),
+		ticker:          time.NewTicker(time.Duration(config.Interval) * time.Second),
 		buffer:          NewSafeList(),
-		listener:        make(chan *Data, batchSize*2),
+		listener:        make(chan *Data, config.BatchSize*2),
+		drainRequest:    make(chan chan error),
+		drainFlush:      make(chan chan error),
 		watchFlushForce: atomic.Int64{},
 		watchFlush:      atomic.Int64{},
 		watchStop:       make(chan struct{}),
 		stopped:         make(chan struct{}),
 		dirWatchStop:    make(chan struct{}),
 		dirWatchStopped: make(chan struct{}),
+		shutdownDone:    make(chan struct{}),
+		instanceLog:     o.instanceLog("app", config.AppId),
+	}
+	c.abortCtx, c.abortCancel = context.WithCancel(context.Background())
+	c.conf.Store(&config)
+	c.compression.Store(&compression)
+	if config.DedupeSize > 0 {
+		c.acked = newUUIDFilter(config.DedupeSize)
 	}
 	if config.TempDir != "" {
-		abs, err := checkAndMakeFolder(config.TempDir)
-		if err != nil {
-			return nil, err
-		}
-		config.TempDir = abs
 		p := newPrinter(&printerConf{
 			app:        config.AppId,
 			folder:     config.TempDir,
 			maxSize:    100,
 			maxAge:     0,
 			maxBackups: 0,
-			compress:   true,
 		})
 		c.logPrinter = p
-		c.conf.TempDir = config.TempDir
 		c.watchDir()
 	}
 	c.listen()
 	go func() {
-		defer func() {
-			c.stopped <- struct{}{}
-		}()
+		defer close(c.stopped)
 		for {
 			select {
 			case <-c.watchStop: //退出前强制将所有日志发送到服务器
-				sdLogInfo("batch consumer watcher stopping......")
-				//强制将所有数据发送到服务器
-				_ = c.innerFlush(true)
+				c.log.Info("batch consumer watcher stopping......")
+				//强制将所有数据发送到服务器，超时后写入缓存文件
+				_ = c.flushAll()
 				c.watchFlushForce.Store(0)
 				c.watchFlush.Store(0)
-				sdLogInfo("batch consumer stopped send log count:%d", c.countSend)
+				c.log.Info("batch consumer stopped",
+					"sent", atomic.LoadInt64(&c.countSend), "deduped", atomic.LoadInt64(&c.countDeduped))
+				//最后上传缓存文件
+				if c.logPrinter != nil {
+					close(c.dirWatchStop)
+					<-c.dirWatchStopped
+				}
 				return
 			case <-c.ticker.C: //定时传输日志
-				sdLogInfo("ticker flush at:%s", time.Now().Format(time.RFC3339))
+				c.log.Debug("ticker flush")
 				//清空计数值
 				c.watchFlushForce.Store(0)
 				c.watchFlush.Store(0)
 				//发送数据
 				_ = c.innerFlush(true)
+			case done := <-c.drainFlush: //Drain，发送之前写入的所有日志
+				c.log.Debug("drain flush")
+				done <- c.flushAll()
 			default: //合批发送
 				force := c.watchFlushForce.Swap(0)
 				notForce := c.watchFlush.Swap(0)
 				if force > 0 {
-					sdLogInfo("force flush count:%d at:%s", force, time.Now().Format(time.RFC3339))
+					c.log.Debug("force flush", "count", force)
 					//合批发送
 					_ = c.innerFlush(true)
 				} else if notForce > 0 {
-					sdLogInfo("not force flush count:%d at:%s", notForce, time.Now().Format(time.RFC3339))
+					c.log.Debug("not force flush", "count", notForce)
 					//合批发送
 					_ = c.innerFlush(false)
 				} else {
@@ -158,7 +186,7 @@ func NewBatchConsumer(config SDBatchConfig) (SDConsumer, error) {
 		}
 	}()
 
-	sdLogInfo("Mode: batch consumer, appId: %s, serverUrl: %s", c.conf.AppId, c.conf.ServerUrl)
+	c.log.Info("Mode: batch consumer", "server", config.ServerUrl, "codec", compression.Codec, "protocol", config.Protocol)
 
 	return c, nil
 }
@@ -170,58 +198,70 @@ func (c *SDBatchConsumer) listen() {
 			select {
 			case d, ok := <-c.listener:
 				if !ok {
-					sdLogInfo("batch consumer listener stopping......")
-					//关闭信道，准备退出。先关闭定时器，再关闭发送监听信道。
+					c.log.Info("batch consumer listener stopping......")
+					//关闭信道，准备退出。先关闭定时器，再关闭发送监听信道，文件监听在日志发送完成后关闭。
 					c.ticker.Stop()
-					close(c.dirWatchStop)
-					<-c.dirWatchStopped
 					close(c.watchStop)
 					return
 				}
-				atomic.AddInt64(&c.count, 1)
-				c.buffer.PushBack(d)
-				//合批发送
-				if c.buffer.Len() >= c.conf.BatchSize {
-					c.watchFlush.Add(1) //非强制分割，根据情况分割
+				c.push(d)
+			case done := <-c.drainRequest:
+				//Drain之前写入的日志都已在通道中，先全部放入缓存，再交给发送进程
+				for n := len(c.listener); n > 0; n-- {
+					c.push(<-c.listener)
 				}
+				c.drainFlush <- done
 			}
 		}
 	}()
 
 }
 
+// push 日志去重后放入缓存，达到BatchSize时通知发送
+func (c *SDBatchConsumer) push(d *Data) {
+	atomic.AddInt64(&c.count, 1)
+	if c.acked != nil && c.acked.Contains(d.UUID) {
+		atomic.AddInt64(&c.countDeduped, 1)
+		c.log.Info("drop duplicate event", "uuid", d.UUID)
+		return
+	}
+	c.buffer.PushBack(d)
+	//合批发送
+	if c.buffer.Len() >= c.config().BatchSize {
+		c.watchFlush.Add(1) //非强制分割，根据情况分割
+	}
+}
+
 // watchDir 定时检查日志保存文件夹，上传日志文件
 func (c *SDBatchConsumer) watchDir() {
 	go func() {
-		d := time.Duration(c.conf.Interval) * time.Second
-		ticker := time.NewTicker(d)
+		ticker := time.NewTicker(time.Duration(c.config().Interval) * time.Second)
 		for {
 			select {
 			case <-ticker.C: //定时传输日志
-				slog.Info("watchDir ticker, start processPath upload logFile")
+				c.log.Debug("watchDir ticker, start processPath upload logFile")
 				lineCount, err := c.logPrinter.LogLine()
 				if err != nil {
-					sdLogError("watchDir ticker read file line failed error:%s", err.Error())
+					c.log.Error("watchDir read file line failed", "file", c.logPrinter.conf.filename, "error", err)
 					_ = c.logPrinter.ForceRotate() //强制切割
-					time.Sleep(3 * time.Second)    //等待日志文件压缩完成
 				} else if lineCount > 0 {
 					_ = c.logPrinter.ForceRotate() //强制切割
-					time.Sleep(3 * time.Second)    //等待日志文件压缩完成
 				}
+				c.compressBackups()
 				c.processPath()
-				ticker.Reset(d)
+				//间隔可能已被UpdateConfig修改
+				ticker.Reset(time.Duration(c.config().Interval) * time.Second)
 			case <-c.dirWatchStop:
-				slog.Info("batch consumer watchDir stopping......")
+				c.log.Info("batch consumer watchDir stopping......")
 				ticker.Stop()
 				lineCount, err := c.logPrinter.LogLine()
 				if err != nil {
-					sdLogError("watchDir ticker read file line failed error:%s", err.Error())
-					_ = c.logPrinter.Rotate()   //强制切割
-					time.Sleep(3 * time.Second) //等待日志文件压缩完成
+					c.log.Error("watchDir read file line failed", "file", c.logPrinter.conf.filename, "error", err)
+					_ = c.logPrinter.Rotate() //强制切割
 				} else if lineCount > 0 {
-					_ = c.logPrinter.Rotate()   //强制切割
-					time.Sleep(3 * time.Second) //等待日志文件压缩完成
+					_ = c.logPrinter.Rotate() //强制切割
 				}
+				c.compressBackups()
 				c.processPath()
 				c.dirWatchStopped <- struct{}{}
 				return
@@ -231,62 +271,110 @@ func (c *SDBatchConsumer) watchDir() {
 }
 
 func (c *SDBatchConsumer) Add(d Data) error {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		c.log.Error(ErrConsumerClosed.Error())
+		return ErrConsumerClosed
+	}
 	c.listener <- &d
-	sdLogInfo("Enqueue event data: %v", d)
+	c.log.Info("enqueue event", "data", d)
 
 	return nil
 }
 
 func (c *SDBatchConsumer) Flush() error {
 	c.watchFlushForce.Add(1)
-	sdLogInfo("flush data")
+	c.log.Info("flush data")
 	return nil
 }
 
-// pack 打包数据，准备发送
-func (c *SDBatchConsumer) pack() (*bytes.Buffer, int, error) {
+// Drain 同步发送调用之前写入的所有日志，发送失败的日志写入TempDir后返回。
+// 没有设置TempDir时，有日志因发送失败被丢弃则返回错误；ctx到期时返回ctx.Err()，日志仍会在后台继续发送。
+// Flush只通知发送进程，需要确认日志不会丢失时（例如保存导入进度前）使用Drain。
+func (c *SDBatchConsumer) Drain(ctx context.Context) error {
+	done := make(chan error, 1)
+	c.closeMutex.RLock()
+	if c.closed {
+		c.closeMutex.RUnlock()
+		c.log.Error(ErrConsumerClosed.Error())
+		return ErrConsumerClosed
+	}
+	//持有读锁时listener不会被关闭，listen进程一定会接收请求
+	c.drainRequest <- done
+	c.closeMutex.RUnlock()
+	c.log.Info("drain data")
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pack 打包数据，准备发送。返回打包的日志和其中所有日志的#uuid
+func (c *SDBatchConsumer) pack() (*bytes.Buffer, []string, error) {
 	b := bytes.NewBuffer([]byte{})
-	size := 0
-	for size < c.conf.BatchSize {
+	batchSize := c.config().BatchSize
+	uuids := make([]string, 0, batchSize)
+	for len(uuids) < batchSize {
 		data, ok := c.buffer.PopFront()
 		if !ok {
 			break
 		}
 		bs, err := json.Marshal(data)
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, err
 		}
-		size += 1
+		uuids = append(uuids, data.(*Data).UUID)
 		b.Write(bs)
 		b.Write([]byte("\n"))
 	}
 
-	return b, size, nil
+	return b, uuids, nil
 }
 
+// innerFlush 发送一批日志，发送成功或写入缓存文件时返回nil，返回错误表示这批日志被丢弃
 func (c *SDBatchConsumer) innerFlush(force bool) error {
 	//没有数据时直接返回
 	if c.buffer.Len() == 0 {
 		return nil
 	}
-	if c.buffer.Len() < c.conf.BatchSize && !force {
+	if c.buffer.Len() < c.config().BatchSize && !force {
 		return nil
 	}
-	b, size, err := c.pack()
+	b, uuids, err := c.pack()
 	if err != nil {
 		return err
 	}
+	size := len(uuids)
+	//批次ID在重试时保持不变
+	batchId := batchIdOf(uuids)
 	atomic.AddInt64(&c.countSend, int64(size))
 	params := parseTime(b.Bytes())
+	if c.abortCtx.Err() != nil {
+		//已超过关闭期限，不再发送，直接写入缓存文件
+		if c.logPrinter == nil {
+			c.log.Error("batch consumer shutdown timeout, drop log", "batch_id", batchId, "size", size)
+			return c.abortCtx.Err()
+		}
+		return c.writeFile(batchId, size, params)
+	}
 	for i := 0; i < 3; i++ {
-		err = c.send(params, size)
+		err = c.sendBatch(params, size, batchId)
 		if err != nil {
-			sdLogError("consumer batch send to http failed error:%s", err.Error())
+			c.log.Error("send batch failed", "batch_id", batchId, "size", size, "attempt", i+1, "error", err)
 			if i == 2 {
-				c.writeFile(params)
-				return err
+				if c.logPrinter == nil {
+					return err
+				}
+				return c.writeFile(batchId, size, params)
 			}
 		} else {
+			if c.acked != nil {
+				c.acked.Add(uuids...)
+			}
 			return nil
 		}
 	}
@@ -294,59 +382,215 @@ func (c *SDBatchConsumer) innerFlush(force bool) error {
 	return c.innerFlush(force)
 }
 
-func (c *SDBatchConsumer) writeFile(data []byte) {
+// flushAll 发送缓存中的所有日志，返回被丢弃的批次的错误
+func (c *SDBatchConsumer) flushAll() error {
+	var errs []error
+	for c.buffer.Len() > 0 {
+		if err := c.innerFlush(true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeFile 发送失败的批次写入缓存文件，批次标记和日志一次写入，不会被切割到不同的文件
+func (c *SDBatchConsumer) writeFile(batchId string, size int, data []byte) error {
 	if c.logPrinter == nil {
-		return
+		return nil
 	}
-	_, err := c.logPrinter.Write(data)
+	_, err := c.logPrinter.Write(append(newSpoolMarker(batchId, size), data...))
 	if err != nil {
-		sdLogError("SDBatchConsumer writeFile error:%s", err.Error())
+		c.log.Error("write temp file failed", "file", c.logPrinter.conf.filename, "error", err)
 	}
+	return err
 }
 
+// Close 关闭consumer，等待所有日志发送完成。可以重复调用
 func (c *SDBatchConsumer) Close() error {
-	sdLogInfo("batch consumer stopping....... log count=%d", c.count)
-	close(c.listener)
-	<-c.stopped
-	if c.logPrinter != nil {
-		_ = c.logPrinter.Close()
-	}
+	return c.Shutdown(context.Background())
+}
 
-	return nil
+// Shutdown 关闭consumer并发送所有缓存的日志。ctx到期后中断发送，未发送的日志写入TempDir，下次启动时上传；
+// 没有设置TempDir时未发送的日志会被丢弃。超时返回ctx.Err()，可以重复调用。
+func (c *SDBatchConsumer) Shutdown(ctx context.Context) error {
+	c.shutdownOnce.Do(func() {
+		c.log.Info("batch consumer stopping.......", "count", atomic.LoadInt64(&c.count))
+		c.closeMutex.Lock()
+		c.closed = true
+		close(c.listener)
+		c.closeMutex.Unlock()
+		go func() {
+			<-c.stopped
+			if c.logPrinter != nil {
+				_ = c.logPrinter.Close()
+			}
+			c.abortCancel()
+			close(c.shutdownDone)
+		}()
+	})
+
+	select {
+	case <-c.shutdownDone:
+		return nil
+	case <-ctx.Done():
+		//中断发送，剩余的日志写入缓存文件后退出
+		c.log.Warn("batch consumer shutdown timeout, write remaining log to temp dir", "dir", c.config().TempDir)
+		c.abortCancel()
+		<-c.shutdownDone
+		return ctx.Err()
+	}
 }
 
 func (c *SDBatchConsumer) IsStringent() bool {
 	return false
 }
 
-func (c *SDBatchConsumer) send(data []byte, size int) (err error) {
-	var encodedData []byte
-	if c.conf.Compress {
-		encodedData, err = encodeData(data)
-	} else {
-		encodedData = data
+// compressionConf 兼容旧的Compress参数，未设置Compression时使用gzip默认等级
+func (conf *SDBatchConfig) compressionConf() SDCompression {
+	if conf.Compression.Codec != "" {
+		return conf.Compression
+	}
+	if conf.Compress {
+		return SDCompression{Codec: CodecGzip}
+	}
+	return SDCompression{Codec: CodecNone}
+}
+
+// normalize 校验配置并填充默认值，返回使用的压缩配置
+func (conf *SDBatchConfig) normalize() (SDCompression, error) {
+	if conf.ServerUrl == "" {
+		return SDCompression{}, errors.New("ServerUrl can not be empty")
+	}
+	if conf.BatchSize > MaxBatchSize {
+		conf.BatchSize = MaxBatchSize
+	} else if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultBatchSize
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = time.Duration(DefaultTimeOut) * time.Millisecond
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultInterval
+	}
+	compression := conf.compressionConf()
+	err := compression.validate()
+	if err != nil {
+		return SDCompression{}, err
+	}
+	if conf.Protocol == 0 {
+		conf.Protocol = ProtocolV1
+	}
+	err = conf.Protocol.validate()
+	if err != nil {
+		return SDCompression{}, err
+	}
+	return compression, nil
+}
+
+// UpdateConfig 在运行中修改配置，不需要重启。ServerUrl、AppToken、BatchSize、Interval、Timeout、Compression、
+// Protocol和Sign在下一次发送时生效，正在进行的发送使用旧的配置。TempDir、AppId和DedupeSize不能在运行中修改。
+func (c *SDBatchConsumer) UpdateConfig(config SDBatchConfig) error {
+	compression, err := config.normalize()
+	if err != nil {
+		return err
+	}
+	if config.TempDir != "" {
+		config.TempDir, err = filepath.Abs(config.TempDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.updateMutex.Lock()
+	defer c.updateMutex.Unlock()
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return ErrConsumerClosed
+	}
+	old := c.config()
+	if config.TempDir != old.TempDir || config.AppId != old.AppId || config.DedupeSize != old.DedupeSize {
+		return errors.New("TempDir, AppId and DedupeSize can not be changed at runtime")
+	}
+	c.conf.Store(&config)
+	c.compression.Store(&compression)
+	if config.Interval != old.Interval {
+		c.ticker.Reset(time.Duration(config.Interval) * time.Second)
+	}
+	c.log.Info("update config", "server", config.ServerUrl, "batch_size", config.BatchSize,
+		"interval", config.Interval, "codec", compression.Codec, "protocol", config.Protocol)
+	return nil
+}
+
+// config 当前配置，调用方不能修改返回值
+func (c *SDBatchConsumer) config() *SDBatchConfig {
+	return c.conf.Load()
+}
+
+// currentCompression 当前使用的压缩配置
+func (c *SDBatchConsumer) currentCompression() SDCompression {
+	return *c.compression.Load()
+}
+
+// downgradeCompression 服务端不支持当前压缩算法时降级为gzip，已经是gzip或不压缩时返回false
+func (c *SDBatchConsumer) downgradeCompression() bool {
+	current := c.currentCompression()
+	if current.Codec == CodecGzip || current.Codec == CodecNone {
+		return false
+	}
+	c.log.Warn("server does not support codec, fallback to gzip", "codec", current.Codec)
+	c.compression.Store(&SDCompression{Codec: CodecGzip})
+	return true
+}
+
+// compressBackups 压缩切割出的缓存文件。缓存文件总是压缩保存，上报不压缩时使用gzip
+func (c *SDBatchConsumer) compressBackups() {
+	codec := c.currentCompression()
+	if codec.Codec == CodecNone {
+		codec = SDCompression{Codec: CodecGzip}
 	}
+	err := c.logPrinter.compressBackups(codec)
+	if err != nil {
+		c.log.Error("compress temp file failed", "error", err)
+	}
+}
+
+// sendBatch 发送一个批次，服务端不支持当前压缩算法时降级为gzip后立即重发
+func (c *SDBatchConsumer) sendBatch(data []byte, size int, batchId string) error {
+	err := c.send(data, size, batchId)
+	if errors.Is(err, errUnsupportedCodec) && c.downgradeCompression() {
+		err = c.send(data, size, batchId)
+	}
+	return err
+}
+
+func (c *SDBatchConsumer) send(data []byte, size int, batchId string) (err error) {
+	conf := c.config()
+	compression := c.currentCompression()
+	encodedData, err := encodeData(data, compression)
 	if err != nil {
 		return err
 	}
 	r := &request{
-		App:      c.conf.AppId,
-		Token:    c.conf.AppToken,
+		App:      conf.AppId,
+		Token:    conf.AppToken,
 		SDK:      "go-sdk",
 		Version:  shimmerdata_go.Version,
-		Compress: c.conf.Compress,
+		Compress: compression.Codec != CodecNone,
+		Codec:    string(compression.Codec),
+		BatchId:  batchId,
 		Size:     int64(size),
 		Log:      encodedData,
 	}
-	reqData, err := json.Marshal(r)
+	req, err := c.newReportRequest(r)
 	if err != nil {
 		return err
 	}
-	postData := bytes.NewBuffer(reqData)
+
+	req = req.WithContext(c.abortCtx)
 
 	var resp *http.Response
-	req, _ := http.NewRequest("POST", c.conf.ServerUrl+"/LogServer/log/report", postData)
-	client := &http.Client{Timeout: c.conf.Timeout}
+	client := &http.Client{Timeout: conf.Timeout}
 	resp, err = client.Do(req)
 
 	if err != nil {
@@ -354,6 +598,9 @@ func (c *SDBatchConsumer) send(data []byte, size int) (err error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnsupportedMediaType {
+		return errUnsupportedCodec
+	}
 	body, _ := io.ReadAll(resp.Body)
 	var result struct {
 		Code int
@@ -378,34 +625,38 @@ func (c *SDBatchConsumer) send(data []byte, size int) (err error) {
 
 // processPath 遍历文件夹，解析所有文件并上传
 func (c *SDBatchConsumer) processPath() {
-	fileDir := c.conf.TempDir
+	fileDir := c.config().TempDir
 	info, err := os.Stat(fileDir)
 	if err != nil {
-		sdLogError("state tempDir error:%s", err.Error())
+		c.log.Error("stat temp dir failed", "dir", fileDir, "error", err)
 		return
 	}
 	if info.IsDir() {
 		files, err := os.ReadDir(fileDir)
 		if err != nil {
-			sdLogError("read tempDir error:%s", err.Error())
+			c.log.Error("read temp dir failed", "dir", fileDir, "error", err)
 			return
 		}
 		for _, file := range files {
+			if c.abortCtx.Err() != nil {
+				//关闭超时，剩余文件下次启动时上传
+				return
+			}
 			if file.Name() == filepath.Base(c.logPrinter.conf.filename) {
 				continue
 			}
 			filePath := filepath.Join(fileDir, file.Name())
-			if !file.IsDir() {
+			if !file.IsDir() && filepath.Ext(filePath) != ".tmp" {
 				//文件
-				err = c.uploadFile(filePath)
+				filePath, err = c.sendSpoolFile(filePath)
 				if err != nil {
-					sdLogError("processPath uploadFile error:%s", err.Error())
+					c.log.Error("upload temp file failed", "file", filePath, "error", err)
 					return
 				}
 				//删除文件
 				err = os.Remove(filePath)
 				if err != nil {
-					sdLogError("processPath remove file:%s error:%s", filePath, err.Error())
+					c.log.Error("remove temp file failed", "file", filePath, "error", err)
 					return
 				}
 			}
@@ -413,17 +664,42 @@ func (c *SDBatchConsumer) processPath() {
 	}
 }
 
-// Gzip
-func encodeData(data []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	gw := gzip.NewWriter(&buf)
-
-	_, err := gw.Write(data)
+// sendSpoolFile 重新发送缓存文件。有批次标记的文件按批次使用原来的批次ID发送，中途失败时下次从头发送，
+// 已发送的批次由服务端根据批次ID去重；旧版本写入的没有批次标记的文件整个上传。返回最终的文件路径
+func (c *SDBatchConsumer) sendSpoolFile(filePath string) (string, error) {
+	marked, err := hasSpoolMarker(filePath)
 	if err != nil {
-		_ = gw.Close()
-		return nil, err
+		return filePath, err
+	}
+	if !marked {
+		return c.uploadSpoolFile(filePath)
 	}
-	_ = gw.Close()
+	err = readSpoolBatches(filePath, c.config().BatchSize, func(batchId string, lines [][]byte) error {
+		if c.abortCtx.Err() != nil {
+			return c.abortCtx.Err()
+		}
+		data := append(bytes.Join(lines, []byte("\n")), '\n')
+		if err := c.sendBatch(data, len(lines), batchId); err != nil {
+			return fmt.Errorf("send batch %s failed: %w", batchId, err)
+		}
+		return nil
+	})
+	if err == nil {
+		c.log.Info("send temp file success", "file", filePath)
+	}
+	return filePath, err
+}
 
-	return buf.Bytes(), nil
+// uploadSpoolFile 上传缓存文件，服务端不支持文件的压缩算法时转为gzip后重新上传。返回最终上传的文件路径
+func (c *SDBatchConsumer) uploadSpoolFile(filePath string) (string, error) {
+	err := c.uploadFile(filePath)
+	if errors.Is(err, errUnsupportedCodec) && codecFromFilename(filePath) != CodecGzip {
+		//服务端不支持该压缩算法，转为gzip后重新上传
+		c.downgradeCompression()
+		filePath, err = transcodeFile(filePath, SDCompression{Codec: CodecGzip})
+		if err == nil {
+			err = c.uploadFile(filePath)
+		}
+	}
+	return filePath, err
 }
@@ -1,7 +1,13 @@
 package shimmerdata
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -53,3 +59,170 @@ func TestNewBatchConsumerWithConfig(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestBatchConsumerShutdown(t *testing.T) {
+	// 模拟无响应的服务器，请求一直阻塞到测试结束
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-block:
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	dir := t.TempDir()
+	c, err := NewBatchConsumer(SDBatchConfig{
+		TempDir:   dir,
+		ServerUrl: server.URL,
+		AppId:     "app",
+		AppToken:  "token",
+		BatchSize: 10,
+		Interval:  60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	for i := 0; i < 25; i++ {
+		err = client.Track("123456", "7890123", "event_name", map[string]interface{}{"i": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("shutdown took too long: %s", time.Since(start))
+	}
+
+	// 未发送的日志全部写入缓存目录
+	lines := 0
+	files, _ := os.ReadDir(dir)
+	for _, file := range files {
+		err = ReadSpool(filepath.Join(dir, file.Name()), func(int, []byte) error {
+			lines++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines != 25 {
+		t.Fatalf("expect 25 events in temp dir, got %d", lines)
+	}
+	// 默认配置上报不压缩，切割出的缓存文件仍然使用gzip压缩
+	spool, err := ListSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := 0
+	for _, f := range spool {
+		if f.Active {
+			continue
+		}
+		backups++
+		if f.Codec != CodecGzip {
+			t.Fatalf("backup should be compressed by gzip: %+v", f)
+		}
+	}
+	if backups == 0 {
+		t.Fatal("expect rotated temp files")
+	}
+
+	if err = client.Track("123456", "7890123", "event_name", nil); !errors.Is(err, ErrConsumerClosed) {
+		t.Fatalf("add after close should return ErrConsumerClosed, got %v", err)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchConsumerUpdateConfig(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits[name]++
+			mu.Unlock()
+		})
+	}
+	old := httptest.NewServer(handler("old"))
+	defer old.Close()
+	current := httptest.NewServer(handler("new"))
+	defer current.Close()
+
+	config := SDBatchConfig{
+		TempDir:   t.TempDir(),
+		ServerUrl: old.URL,
+		AppId:     "app",
+		AppToken:  "token",
+		BatchSize: 10,
+		Interval:  60,
+	}
+	c, err := NewBatchConsumer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := New(c)
+	track := func() {
+		if err := client.Track("123456", "7890123", "event_name", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Flush是异步的，日志可能还没有进入缓冲区，重复Flush直到请求到达服务器
+	waitHits := func(name string, n int) {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if err := client.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			got := hits[name]
+			mu.Unlock()
+			if got >= n {
+				return
+			}
+		}
+		t.Fatalf("server %s did not receive %d requests", name, n)
+	}
+	track()
+	waitHits("old", 1)
+
+	// 切换服务器地址后，新的请求发送到新服务器
+	config.ServerUrl = current.URL
+	config.BatchSize = 100
+	if err = c.(*SDBatchConsumer).UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	track()
+	waitHits("new", 1)
+	mu.Lock()
+	oldHits := hits["old"]
+	mu.Unlock()
+	if oldHits != 1 {
+		t.Fatalf("old server should not receive requests after update, got %d", oldHits)
+	}
+	if c.(*SDBatchConsumer).config().BatchSize != 100 {
+		t.Fatal("batch size is not updated")
+	}
+
+	config.AppId = "other"
+	if err = c.(*SDBatchConsumer).UpdateConfig(config); err == nil {
+		t.Fatal("AppId should not be changed at runtime")
+	}
+
+	// 配置文件中的类型和当前consumer不一致时不能应用
+	err = client.ApplyConfig(&Config{Type: ConsumerLog, Log: LogSection{Directory: t.TempDir()}})
+	if err == nil {
+		t.Fatal("consumer type should not be changed at runtime")
+	}
+}
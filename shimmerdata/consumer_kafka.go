@@ -0,0 +1,311 @@
+package shimmerdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaKeyField 消息key使用的字段，相同key的日志写入同一个分区，保证同一玩家的日志有序
+type KafkaKeyField int32
+
+const (
+	KafkaKeyDistinctId KafkaKeyField = 0 // 使用#distinct_id，为空时使用#account_id
+	KafkaKeyAccountId  KafkaKeyField = 1 // 使用#account_id，为空时使用#distinct_id
+)
+
+// KafkaProducer 将消息写入kafka，*kafka.Writer 实现了该接口。测试时可以替换为进程内的实现
+type KafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// SDKafkaConsumer 将日志以JSON格式写入kafka。写入失败的批次直接丢弃并计入dropped，
+// 与SDBatchConsumer不同，不会写入本地缓存文件
+type SDKafkaConsumer struct {
+	conf         SDKafkaConfig      //启动配置
+	producer     KafkaProducer      //kafka producer
+	count        int64              //统计总数
+	countSend    int64              //统计发送成功总数
+	countDropped int64              //统计发送失败丢弃的总数
+	listener     chan *Data         //日志通道，写满时Add阻塞
+	flushCh      chan chan error    //强制发送请求
+	stopped      chan struct{}      //发送进程退出信号
+	closed       bool               //是否已关闭
+	closeMutex   sync.RWMutex       //关闭listener时阻止新的写入
+	shutdownOnce sync.Once          //保证只关闭一次
+	shutdownDone chan struct{}      //关闭完成信号
+	closeErr     error              //关闭producer的错误
+	abortCtx     context.Context    //关闭超时后取消，中断正在进行的写入
+	abortCancel  context.CancelFunc //取消abortCtx
+	instanceLog                     //内部日志
+}
+
+// SDKafkaConfig 启动配置参数
+type SDKafkaConfig struct {
+	Brokers     []string      // kafka broker地址
+	Topic       string        // 写入的topic
+	KeyField    KafkaKeyField // 消息key使用的字段，默认KafkaKeyDistinctId
+	BatchSize   int           // 一次写入的消息个数，默认DefaultBatchSize
+	Interval    int           // 自动发送间隔时间 (秒)，默认DefaultInterval
+	Timeout     time.Duration // 一次写入的超时时间，默认DefaultTimeOut毫秒
+	ChannelSize int           // 日志通道大小，写满时Add阻塞，默认BatchSize的两倍
+	Compression SDCompression // kafka消息压缩算法，Level不生效（设置时输出警告），使用各算法的默认等级
+	Producer    KafkaProducer // 自定义producer，设置后忽略Brokers
+}
+
+// NewKafkaConsumer 创建SDKafkaConsumer
+func NewKafkaConsumer(config SDKafkaConfig, opts ...Option) (SDConsumer, error) {
+	o := newOptions(opts)
+	if config.Topic == "" {
+		msg := "Topic can not be empty"
+		o.logger.Info(msg)
+		return nil, errors.New(msg)
+	}
+	if config.Producer == nil && len(config.Brokers) == 0 {
+		msg := "Brokers can not be empty"
+		o.logger.Info(msg)
+		return nil, errors.New(msg)
+	}
+	if config.KeyField != KafkaKeyDistinctId && config.KeyField != KafkaKeyAccountId {
+		msg := fmt.Sprintf("unknown kafka key field: %d", config.KeyField)
+		o.logger.Info(msg)
+		return nil, errors.New(msg)
+	}
+	if config.Compression.Codec == "" {
+		config.Compression.Codec = CodecNone
+	}
+	err := config.Compression.validate()
+	if err != nil {
+		o.logger.Info(err.Error())
+		return nil, err
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Duration(DefaultTimeOut) * time.Millisecond
+	}
+	if config.ChannelSize <= 0 {
+		config.ChannelSize = config.BatchSize * 2
+	}
+
+	if config.Compression.Level != 0 {
+		o.logger.Warn("compress level is not supported by kafka consumer, use the default level", "level", config.Compression.Level)
+	}
+
+	producer := config.Producer
+	if producer == nil {
+		producer = newKafkaWriter(config)
+	}
+
+	c := &SDKafkaConsumer{
+		conf:         config,
+		producer:     producer,
+		listener:     make(chan *Data, config.ChannelSize),
+		flushCh:      make(chan chan error),
+		stopped:      make(chan struct{}),
+		shutdownDone: make(chan struct{}),
+		instanceLog:  o.instanceLog("topic", config.Topic),
+	}
+	c.abortCtx, c.abortCancel = context.WithCancel(context.Background())
+	c.listen()
+
+	c.log.Info("Mode: kafka consumer", "brokers", config.Brokers, "codec", config.Compression.Codec)
+
+	return c, nil
+}
+
+// newKafkaWriter 创建写入Brokers的kafka.Writer
+func newKafkaWriter(config SDKafkaConfig) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Topic:        config.Topic,
+		Balancer:     &kafka.Hash{}, //按key分区
+		BatchSize:    config.BatchSize,
+		BatchTimeout: 10 * time.Millisecond, //合批由consumer完成，不再等待
+		RequiredAcks: kafka.RequireAll,
+		Compression:  kafkaCompression(config.Compression.Codec),
+	}
+}
+
+// kafkaCompression 转换为kafka的压缩算法
+func kafkaCompression(codec CompressCodec) kafka.Compression {
+	switch codec {
+	case CodecGzip:
+		return kafka.Gzip
+	case CodecZstd:
+		return kafka.Zstd
+	case CodecSnappy:
+		return kafka.Snappy
+	default:
+		return 0
+	}
+}
+
+// listen 接收日志，合批写入kafka
+func (c *SDKafkaConsumer) listen() {
+	go func() {
+		defer close(c.stopped)
+		ticker := time.NewTicker(time.Duration(c.conf.Interval) * time.Second)
+		defer ticker.Stop()
+		batch := make([]kafka.Message, 0, c.conf.BatchSize)
+		for {
+			select {
+			case d, ok := <-c.listener:
+				if !ok {
+					c.log.Info("kafka consumer listener stopping......")
+					_ = c.write(batch)
+					c.log.Info("kafka consumer stopped",
+						"sent", atomic.LoadInt64(&c.countSend), "dropped", atomic.LoadInt64(&c.countDropped))
+					return
+				}
+				msg, err := c.message(d)
+				if err != nil {
+					c.log.Error("encode event failed", "error", err)
+					continue
+				}
+				batch = append(batch, msg)
+				if len(batch) >= c.conf.BatchSize {
+					_ = c.write(batch)
+					batch = batch[:0]
+				}
+			case done := <-c.flushCh:
+				//先取出通道中已有的日志
+				for len(c.listener) > 0 {
+					d, ok := <-c.listener
+					if !ok {
+						break
+					}
+					msg, err := c.message(d)
+					if err != nil {
+						c.log.Error("encode event failed", "error", err)
+						continue
+					}
+					batch = append(batch, msg)
+				}
+				done <- c.write(batch)
+				batch = batch[:0]
+			case <-ticker.C: //定时写入
+				_ = c.write(batch)
+				batch = batch[:0]
+			}
+		}
+	}()
+}
+
+// message 将日志编码为kafka消息
+func (c *SDKafkaConsumer) message(d *Data) (kafka.Message, error) {
+	bs, err := json.Marshal(d)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:   []byte(c.key(d)),
+		Value: parseTime(bs),
+	}, nil
+}
+
+// key 消息的key，优先使用KeyField指定的字段
+func (c *SDKafkaConsumer) key(d *Data) string {
+	if c.conf.KeyField == KafkaKeyAccountId {
+		if d.AccountId != "" {
+			return d.AccountId
+		}
+		return d.DistinctId
+	}
+	if d.DistinctId != "" {
+		return d.DistinctId
+	}
+	return d.AccountId
+}
+
+// write 写入一批消息，失败的消息会被丢弃，不会重试或缓存
+func (c *SDKafkaConsumer) write(batch []kafka.Message) error {
+	size := len(batch)
+	if size == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(c.abortCtx, c.conf.Timeout)
+	defer cancel()
+	err := c.producer.WriteMessages(ctx, batch...)
+	if err != nil {
+		atomic.AddInt64(&c.countDropped, int64(size))
+		c.log.Error("write batch failed, drop log", "size", size, "error", err)
+		return err
+	}
+	atomic.AddInt64(&c.countSend, int64(size))
+	return nil
+}
+
+func (c *SDKafkaConsumer) Add(d Data) error {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		c.log.Error(ErrConsumerClosed.Error())
+		return ErrConsumerClosed
+	}
+	atomic.AddInt64(&c.count, 1)
+	c.listener <- &d
+	c.log.Info("enqueue event", "data", d)
+
+	return nil
+}
+
+// Flush 立即写入所有缓存的日志，返回写入错误
+func (c *SDKafkaConsumer) Flush() error {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return nil
+	}
+	c.log.Info("flush data")
+	done := make(chan error)
+	c.flushCh <- done
+	return <-done
+}
+
+// Close 关闭consumer，等待所有日志写入完成。可以重复调用
+func (c *SDKafkaConsumer) Close() error {
+	return c.Shutdown(context.Background())
+}
+
+// Shutdown 关闭consumer并写入所有缓存的日志。ctx到期后中断写入，未写入的日志被丢弃，返回ctx.Err()。可以重复调用
+func (c *SDKafkaConsumer) Shutdown(ctx context.Context) error {
+	c.shutdownOnce.Do(func() {
+		c.log.Info("kafka consumer stopping.......", "count", atomic.LoadInt64(&c.count))
+		c.closeMutex.Lock()
+		c.closed = true
+		close(c.listener)
+		c.closeMutex.Unlock()
+		go func() {
+			<-c.stopped
+			c.closeErr = c.producer.Close()
+			c.abortCancel()
+			close(c.shutdownDone)
+		}()
+	})
+
+	select {
+	case <-c.shutdownDone:
+		return c.closeErr
+	case <-ctx.Done():
+		c.log.Warn("kafka consumer shutdown timeout, drop remaining log")
+		c.abortCancel()
+		<-c.shutdownDone
+		return ctx.Err()
+	}
+}
+
+func (c *SDKafkaConsumer) IsStringent() bool {
+	return false
+}
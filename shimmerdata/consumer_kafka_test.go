@@ -0,0 +1,328 @@
+package shimmerdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// memoryProducer 进程内的kafka替代实现，按key记录消息
+type memoryProducer struct {
+	mutex  sync.Mutex
+	writes int
+	byKey  map[string][]kafka.Message
+	block  chan struct{} // 不为空时阻塞写入，直到关闭或ctx取消
+	closed bool
+}
+
+func newMemoryProducer() *memoryProducer {
+	return &memoryProducer{byKey: make(map[string][]kafka.Message)}
+}
+
+func (p *memoryProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if p.block != nil {
+		select {
+		case <-p.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return errors.New("producer closed")
+	}
+	p.writes++
+	for _, msg := range msgs {
+		p.byKey[string(msg.Key)] = append(p.byKey[string(msg.Key)], msg)
+	}
+	return nil
+}
+
+func (p *memoryProducer) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestKafkaConsumer(t *testing.T) {
+	producer := newMemoryProducer()
+	c, err := NewKafkaConsumer(SDKafkaConfig{
+		Topic:     "events",
+		BatchSize: 10,
+		Producer:  producer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	for i := 0; i < 25; i++ {
+		distinctId := []string{"player-1", "player-2"}[i%2]
+		err = client.Track("", distinctId, "event_name", map[string]interface{}{"index": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 只有账号ID时使用账号ID作为key
+	err = client.Track("account-1", "", "event_name", map[string]interface{}{"index": 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if producer.writes != 3 {
+		t.Fatalf("got %d writes, want 3", producer.writes)
+	}
+	if len(producer.byKey["player-1"]) != 13 || len(producer.byKey["player-2"]) != 12 || len(producer.byKey["account-1"]) != 1 {
+		t.Fatalf("unexpected messages by key: %d %d %d",
+			len(producer.byKey["player-1"]), len(producer.byKey["player-2"]), len(producer.byKey["account-1"]))
+	}
+	// 同一玩家的日志保持写入顺序
+	last := -1
+	for _, msg := range producer.byKey["player-1"] {
+		var d Data
+		err = json.Unmarshal(msg.Value, &d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := int(d.Properties["index"].(float64))
+		if index <= last {
+			t.Fatalf("out of order: %d after %d", index, last)
+		}
+		last = index
+	}
+	if !producer.closed {
+		t.Fatal("producer is not closed")
+	}
+	if !errors.Is(c.Add(Data{}), ErrConsumerClosed) {
+		t.Fatal("add after close should fail")
+	}
+}
+
+func TestKafkaConsumerShutdown(t *testing.T) {
+	producer := newMemoryProducer()
+	producer.block = make(chan struct{})
+	c, err := NewKafkaConsumer(SDKafkaConfig{
+		Topic:    "events",
+		Producer: producer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	err = client.Track("", "player-1", "event_name", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+	if len(producer.byKey) != 0 {
+		t.Fatal("blocked write should be aborted")
+	}
+}
+
+// kafkaBroker 进程内的kafka协议服务，支持ApiVersions、Metadata和Produce，用于测试真实的kafka.Writer
+type kafkaBroker struct {
+	listener   net.Listener
+	topic      string
+	partitions int32
+	mutex      sync.Mutex
+	records    []brokerRecord
+}
+
+// brokerRecord broker收到的一条消息
+type brokerRecord struct {
+	partition   int32
+	acks        int16
+	compression kafka.Compression
+	key         string
+	value       []byte
+}
+
+func newKafkaBroker(t *testing.T, topic string, partitions int32) *kafkaBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &kafkaBroker{listener: l, topic: topic, partitions: partitions}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *kafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		version, correlationId, _, msg, err := protocol.ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		var resp protocol.Message
+		switch req := msg.(type) {
+		case *apiversions.Request:
+			resp = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+				{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 2},
+				{ApiKey: int16(protocol.Metadata), MinVersion: 0, MaxVersion: 8},
+				{ApiKey: int16(protocol.Produce), MinVersion: 0, MaxVersion: 8},
+			}}
+		case *metadata.Request:
+			addr := b.listener.Addr().(*net.TCPAddr)
+			topic := metadata.ResponseTopic{Name: b.topic}
+			for i := int32(0); i < b.partitions; i++ {
+				topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{
+					PartitionIndex: i, LeaderID: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1},
+				})
+			}
+			resp = &metadata.Response{
+				Brokers:      []metadata.ResponseBroker{{NodeID: 1, Host: addr.IP.String(), Port: int32(addr.Port)}},
+				ControllerID: 1,
+				Topics:       []metadata.ResponseTopic{topic},
+			}
+		case *produce.Request:
+			r := &produce.Response{}
+			for _, topic := range req.Topics {
+				rt := produce.ResponseTopic{Topic: topic.Topic}
+				for _, p := range topic.Partitions {
+					b.receive(req.Acks, p)
+					rt.Partitions = append(rt.Partitions, produce.ResponsePartition{Partition: p.Partition})
+				}
+				r.Topics = append(r.Topics, rt)
+			}
+			if req.Acks == 0 {
+				continue
+			}
+			resp = r
+		default:
+			return
+		}
+		if err = protocol.WriteResponse(conn, version, correlationId, resp); err != nil {
+			return
+		}
+	}
+}
+
+func (b *kafkaBroker) receive(acks int16, p produce.RequestPartition) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for {
+		r, err := p.RecordSet.Records.ReadRecord()
+		if err != nil {
+			return
+		}
+		key, _ := protocol.ReadAll(r.Key)
+		value, _ := protocol.ReadAll(r.Value)
+		b.records = append(b.records, brokerRecord{
+			partition:   p.Partition,
+			acks:        acks,
+			compression: p.RecordSet.Attributes.Compression(),
+			key:         string(key),
+			value:       value,
+		})
+	}
+}
+
+func (b *kafkaBroker) received() []brokerRecord {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]brokerRecord(nil), b.records...)
+}
+
+func TestKafkaConsumerBroker(t *testing.T) {
+	broker := newKafkaBroker(t, "events", 4)
+	c, err := NewKafkaConsumer(SDKafkaConfig{
+		Brokers:     []string{broker.listener.Addr().String()},
+		Topic:       "events",
+		BatchSize:   10,
+		Timeout:     5 * time.Second,
+		Compression: SDCompression{Codec: CodecGzip, Level: 9}, // Level不生效
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	players := []string{"p1", "p2", "p3", "p4", "p5"}
+	for i := 0; i < 3; i++ {
+		for _, p := range players {
+			if err = client.Track("", p, "login", map[string]interface{}{"i": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := broker.received()
+	if len(records) != 15 {
+		t.Fatalf("expect 15 records, got %d", len(records))
+	}
+	partitions := map[string]int32{}
+	for _, r := range records {
+		if r.acks != int16(kafka.RequireAll) || r.compression != kafka.Gzip {
+			t.Fatalf("unexpected acks %d or compression %v", r.acks, r.compression)
+		}
+		// Hash balancer: 相同key写入相同分区
+		if p, ok := partitions[r.key]; ok && p != r.partition {
+			t.Fatalf("key %s is written to partitions %d and %d", r.key, p, r.partition)
+		}
+		partitions[r.key] = r.partition
+		var d Data
+		if err = json.Unmarshal(r.value, &d); err != nil || d.DistinctId != r.key {
+			t.Fatalf("unexpected record %s: %s %v", r.key, r.value, err)
+		}
+	}
+	if len(partitions) != len(players) {
+		t.Fatalf("unexpected keys: %v", partitions)
+	}
+}
+
+func TestKafkaWriter(t *testing.T) {
+	for codec, expect := range map[CompressCodec]kafka.Compression{
+		CodecNone:   0,
+		CodecGzip:   kafka.Gzip,
+		CodecZstd:   kafka.Zstd,
+		CodecSnappy: kafka.Snappy,
+	} {
+		w := newKafkaWriter(SDKafkaConfig{Brokers: []string{"a:9092", "b:9092"}, Topic: "events", BatchSize: 50, Compression: SDCompression{Codec: codec, Level: 3}})
+		if w.Compression != expect {
+			t.Fatalf("unexpected compression of %s: %v", codec, w.Compression)
+		}
+		if _, ok := w.Balancer.(*kafka.Hash); !ok || w.RequiredAcks != kafka.RequireAll || w.BatchSize != 50 ||
+			w.Topic != "events" || w.Addr.String() != "a:9092,b:9092" {
+			t.Fatalf("unexpected writer: %+v", w)
+		}
+	}
+}
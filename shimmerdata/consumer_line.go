@@ -0,0 +1,220 @@
+package shimmerdata
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelFullPolicy decides what Add does when the channel of a line consumer is full
+type ChannelFullPolicy int32
+
+const (
+	ChannelFullBlock   ChannelFullPolicy = 0 // block until there is room, the default
+	ChannelFullTimeout ChannelFullPolicy = 1 // block at most EnqueueTimeout, then drop the event
+	ChannelFullDrop    ChannelFullPolicy = 2 // drop the event immediately
+)
+
+// ErrChannelFull returned by Add when the event is dropped by ChannelFullTimeout or ChannelFullDrop policy
+var ErrChannelFull = errors.New("add event failed, channel is full")
+
+// lineSink destination of NDJSON lines, its methods are only called by the writing goroutine of lineQueue
+type lineSink interface {
+	writeLine(line string) // write errors are logged by the sink
+	flushBuffer() error    // write buffered lines to os
+	sync() error           // flush buffered lines and fsync if possible
+	closeSink() error      // called after the writing goroutine exits
+}
+
+// lineQueue encode events to NDJSON lines and write them to a lineSink in a single goroutine.
+// it is shared by the consumers writing lines, such as SDLogConsumer and SDWriterConsumer.
+type lineQueue struct {
+	sink          lineSink
+	ch            chan []byte
+	flushCh       chan chan error // flush requests handled by the writing goroutine
+	flushInterval time.Duration   // interval of flushing buffered lines
+	syncInterval  time.Duration   // interval of syncing, 0 means disabled
+	fullPolicy    ChannelFullPolicy
+	timeout       time.Duration  // max time to wait for ChannelFullTimeout policy
+	dropped       atomic.Int64   // events dropped because the channel is full
+	wg            sync.WaitGroup // the writing goroutine
+	pending       sync.WaitGroup // Add and Flush calls in progress, ch is closed after they return
+	mutex         *sync.RWMutex  // only guards sdkClose, never held while waiting for the channel
+	sdkClose      bool
+	log           *slog.Logger
+}
+
+// lineQueueConfig options of lineQueue, zero values are replaced with defaults
+type lineQueueConfig struct {
+	chanSize      int
+	flushInterval time.Duration
+	syncInterval  time.Duration
+	fullPolicy    ChannelFullPolicy
+	timeout       time.Duration
+	log           *slog.Logger
+}
+
+func newLineQueue(sink lineSink, config lineQueueConfig) (*lineQueue, error) {
+	if config.chanSize <= 0 {
+		config.chanSize = DefaultChannelSize
+	}
+	if config.flushInterval <= 0 {
+		config.flushInterval = DefaultFlushInterval
+	}
+	switch config.fullPolicy {
+	case ChannelFullBlock, ChannelFullDrop:
+	case ChannelFullTimeout:
+		if config.timeout <= 0 {
+			return nil, errors.New("EnqueueTimeout must be positive for ChannelFullTimeout policy")
+		}
+	default:
+		return nil, errors.New("unknown channel full policy")
+	}
+	return &lineQueue{
+		sink:          sink,
+		ch:            make(chan []byte, config.chanSize),
+		flushCh:       make(chan chan error),
+		flushInterval: config.flushInterval,
+		syncInterval:  config.syncInterval,
+		fullPolicy:    config.fullPolicy,
+		timeout:       config.timeout,
+		mutex:         new(sync.RWMutex),
+		log:           config.log,
+	}, nil
+}
+
+func (q *lineQueue) start() {
+	q.wg.Add(1)
+	go func() {
+		defer func() {
+			q.wg.Done()
+		}()
+		flushTicker := time.NewTicker(q.flushInterval)
+		defer flushTicker.Stop()
+		var syncTick <-chan time.Time
+		if q.syncInterval > 0 {
+			syncTicker := time.NewTicker(q.syncInterval)
+			defer syncTicker.Stop()
+			syncTick = syncTicker.C
+		}
+		for {
+			select {
+			case rec, ok := <-q.ch:
+				if !ok {
+					return
+				}
+				q.write(rec)
+			case done := <-q.flushCh:
+				// write all queued events first
+				for len(q.ch) > 0 {
+					q.write(<-q.ch)
+				}
+				done <- q.sink.sync()
+			case <-flushTicker.C:
+				err := q.sink.flushBuffer()
+				if err != nil {
+					q.log.Error("flush buffer failed", "error", err)
+				}
+			case <-syncTick:
+				err := q.sink.sync()
+				if err != nil {
+					q.log.Error("sync failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (q *lineQueue) write(rec []byte) {
+	jsonStr := parseTime(rec)
+	q.log.Info("write event", "data", string(jsonStr))
+	q.sink.writeLine(string(jsonStr))
+}
+
+// enter register an Add or Flush call, returns false if the queue is closed
+func (q *lineQueue) enter() bool {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.sdkClose {
+		return false
+	}
+	q.pending.Add(1)
+	return true
+}
+
+func (q *lineQueue) add(d Data) error {
+	jsonBytes, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if !q.enter() {
+		err = errors.New("add event failed, SDK has been closed")
+		q.log.Error(err.Error())
+		return err
+	}
+	defer q.pending.Done()
+
+	switch q.fullPolicy {
+	case ChannelFullDrop:
+		select {
+		case q.ch <- jsonBytes:
+			return nil
+		default:
+		}
+	case ChannelFullTimeout:
+		select {
+		case q.ch <- jsonBytes:
+			return nil
+		default:
+		}
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- jsonBytes:
+			return nil
+		case <-timer.C:
+		}
+	default:
+		q.ch <- jsonBytes
+		return nil
+	}
+	q.dropped.Add(1)
+	q.log.Error(ErrChannelFull.Error(), "dropped", q.dropped.Load())
+	return ErrChannelFull
+}
+
+// flush write queued and buffered lines to the sink and sync it
+func (q *lineQueue) flush() error {
+	if !q.enter() {
+		return nil
+	}
+	defer q.pending.Done()
+	done := make(chan error)
+	q.flushCh <- done
+	return <-done
+}
+
+// close write all queued lines and close the sink
+func (q *lineQueue) close() error {
+	q.mutex.Lock()
+	if q.sdkClose {
+		q.mutex.Unlock()
+		return errors.New("[ShimmerData][error]: SDK has been closed")
+	}
+	q.sdkClose = true
+	q.mutex.Unlock()
+
+	// blocked Add calls are served by the writing goroutine until they return
+	q.pending.Wait()
+	close(q.ch)
+	q.wg.Wait()
+	return q.sink.closeSink()
+}
+
+// queueLen number of events waiting to be written
+func (q *lineQueue) queueLen() int {
+	return len(q.ch)
+}
@@ -1,10 +1,10 @@
 package shimmerdata
 
 import (
-	"encoding/json"
+	"bufio"
 	"errors"
-	"fmt"
 	"os"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -17,17 +17,46 @@ const (
 	RotateHourly       RotateMode = 1    // by the hour
 )
 
+// SyncPolicy decides when the log file is synced to disk
+type SyncPolicy int32
+
+const (
+	SyncNever    SyncPolicy = 0 // never fsync, only flush to os. the os decides when data reaches disk
+	SyncAlways   SyncPolicy = 1 // flush and fsync after every event, the slowest and safest
+	SyncInterval SyncPolicy = 2 // fsync every SDLogConsumerConfig.SyncInterval
+	SyncEvents   SyncPolicy = 3 // fsync after every SDLogConsumerConfig.SyncEvents events
+)
+
+const (
+	DefaultBufferSize    = 64 * 1024   // size of write buffer
+	DefaultFlushInterval = time.Second // interval of flushing write buffer to os
+)
+
 // SDLogConsumer write data to file, it works with LogBus
 type SDLogConsumer struct {
-	directory      string   // directory of log file
-	dateFormat     string   // name format of log file
-	fileSize       int64    // max size of single log file (MByte)
-	fileNamePrefix string   // prefix of log file
-	currentFile    *os.File // current file handler
-	wg             sync.WaitGroup
-	ch             chan []byte
-	mutex          *sync.RWMutex
-	sdkClose       bool
+	directory      string        // directory of log file
+	dateFormat     string        // name format of log file
+	fileSize       int64         // max size of single log file (MByte)
+	fileNamePrefix string        // prefix of log file
+	maxFiles       int           // max number of log files to retain
+	maxAge         time.Duration // max age of log files to retain
+	compress       bool          // gzip finished log files
+	atomicRename   bool          // write to "*.tmp" and rename when finished
+	doneManifest   bool          // write "*.done" manifest when finished
+	namePattern    *regexp.Regexp
+	currentFile    *os.File      // current file handler
+	currentTime    string        // time part of current file name
+	currentIndex   int           // paging index of current file name
+	currentSize    int64         // size of current file
+	writer         *bufio.Writer // write buffer of current file, nil if buffering is disabled
+	bufferSize     int
+	syncPolicy     SyncPolicy
+	syncEvents     int
+	unsynced       int // events written since last fsync
+	queue          *lineQueue
+	finishCh       chan finishTask // rotated files waiting for compression and retention
+	finishWg       sync.WaitGroup
+	instanceLog
 }
 
 type SDLogConsumerConfig struct {
@@ -36,26 +65,39 @@ type SDLogConsumerConfig struct {
 	FileSize       int        // max size of single log file (MByte)
 	FileNamePrefix string     // prefix of log file
 	ChannelSize    int
+	MaxFiles       int               // max number of log files to retain, including the current one. 0 means no limit
+	MaxAge         time.Duration     // log files modified earlier than MaxAge are removed. 0 means no limit
+	Compress       bool              // gzip log files after they are rotated
+	AtomicRename   bool              // write to "<name>.tmp" and rename it to "<name>" when rotated or closed, so that shippers only see complete files. paging index is always used in this mode
+	DoneManifest   bool              // write "<name>.done" with line count and md5 after a file is finished
+	BufferSize     int               // size of write buffer in bytes, default DefaultBufferSize. negative value disables buffering
+	FlushInterval  time.Duration     // write buffer is flushed to os periodically, default DefaultFlushInterval
+	SyncPolicy     SyncPolicy        // when to fsync the log file, default SyncNever. Flush and Close always fsync
+	SyncInterval   time.Duration     // fsync interval for SyncInterval policy
+	SyncEvents     int               // fsync after every SyncEvents events for SyncEvents policy
+	FullPolicy     ChannelFullPolicy // what Add does when the channel is full, default ChannelFullBlock
+	EnqueueTimeout time.Duration     // max time Add waits for ChannelFullTimeout policy
 }
 
-func NewLogConsumer(directory string, r RotateMode) (SDConsumer, error) {
-	return NewLogConsumerWithFileSize(directory, r, 0)
+func NewLogConsumer(directory string, r RotateMode, opts ...Option) (SDConsumer, error) {
+	return NewLogConsumerWithFileSize(directory, r, 0, opts...)
 }
 
 // NewLogConsumerWithFileSize init SDLogConsumer
 // directory: directory of log file
 // r: rotate mode of log file. (in days / hours)
 // size: max size of single log file (MByte)
-func NewLogConsumerWithFileSize(directory string, r RotateMode, size int) (SDConsumer, error) {
+func NewLogConsumerWithFileSize(directory string, r RotateMode, size int, opts ...Option) (SDConsumer, error) {
 	config := SDLogConsumerConfig{
 		Directory:  directory,
 		RotateMode: r,
 		FileSize:   size,
 	}
-	return NewLogConsumerWithConfig(config)
+	return NewLogConsumerWithConfig(config, opts...)
 }
 
-func NewLogConsumerWithConfig(config SDLogConsumerConfig) (SDConsumer, error) {
+func NewLogConsumerWithConfig(config SDLogConsumerConfig, opts ...Option) (SDConsumer, error) {
+	o := newOptions(opts)
 	var df string
 	switch config.RotateMode {
 	case RotateDaily:
@@ -64,7 +106,7 @@ func NewLogConsumerWithConfig(config SDLogConsumerConfig) (SDConsumer, error) {
 		df = "2006-01-02-15"
 	default:
 		errStr := "unknown rotate mode"
-		sdLogInfo(errStr)
+		o.logger.Info(errStr)
 		return nil, errors.New(errStr)
 	}
 
@@ -73,174 +115,144 @@ func NewLogConsumerWithConfig(config SDLogConsumerConfig) (SDConsumer, error) {
 		chanSize = config.ChannelSize
 	}
 
+	if config.MaxFiles < 0 || config.MaxAge < 0 {
+		errStr := "MaxFiles and MaxAge can not be negative"
+		o.logger.Info(errStr)
+		return nil, errors.New(errStr)
+	}
+
+	switch config.SyncPolicy {
+	case SyncNever, SyncAlways:
+	case SyncInterval:
+		if config.SyncInterval <= 0 {
+			errStr := "SyncInterval must be positive for SyncInterval policy"
+			o.logger.Info(errStr)
+			return nil, errors.New(errStr)
+		}
+	case SyncEvents:
+		if config.SyncEvents <= 0 {
+			errStr := "SyncEvents must be positive for SyncEvents policy"
+			o.logger.Info(errStr)
+			return nil, errors.New(errStr)
+		}
+	default:
+		errStr := "unknown sync policy"
+		o.logger.Info(errStr)
+		return nil, errors.New(errStr)
+	}
+	bufferSize := config.BufferSize
+	if bufferSize == 0 {
+		bufferSize = DefaultBufferSize
+	}
+
 	c := &SDLogConsumer{
 		directory:      config.Directory,
 		dateFormat:     df,
 		fileSize:       int64(config.FileSize * 1024 * 1024),
 		fileNamePrefix: config.FileNamePrefix,
-		wg:             sync.WaitGroup{},
-		ch:             make(chan []byte, chanSize),
-		mutex:          new(sync.RWMutex),
-		sdkClose:       false,
+		maxFiles:       config.MaxFiles,
+		maxAge:         config.MaxAge,
+		compress:       config.Compress,
+		atomicRename:   config.AtomicRename,
+		doneManifest:   config.DoneManifest,
+		bufferSize:     bufferSize,
+		syncPolicy:     config.SyncPolicy,
+		syncEvents:     config.SyncEvents,
+		finishCh:       make(chan finishTask, chanSize),
+		instanceLog:    o.instanceLog(),
+	}
+	c.namePattern = c.fileNamePattern()
+	var syncInterval time.Duration
+	if config.SyncPolicy == SyncInterval {
+		syncInterval = config.SyncInterval
+	}
+	var err error
+	c.queue, err = newLineQueue(c, lineQueueConfig{
+		chanSize:      chanSize,
+		flushInterval: config.FlushInterval,
+		syncInterval:  syncInterval,
+		fullPolicy:    config.FullPolicy,
+		timeout:       config.EnqueueTimeout,
+		log:           o.logger,
+	})
+	if err != nil {
+		o.logger.Info(err.Error())
+		return nil, err
 	}
 
 	return c, c.init()
 }
 
 func (c *SDLogConsumer) Add(d Data) error {
-	var err error = nil
-	c.mutex.Lock()
-	defer func() {
-		c.mutex.Unlock()
-	}()
-	if c.sdkClose {
-		err = errors.New("add event failed, SDK has been closed")
-		sdLogError(err.Error())
-	} else {
-		jsonBytes, jsonErr := json.Marshal(d)
-		if jsonErr != nil {
-			err = jsonErr
-		} else {
-			c.ch <- jsonBytes
-		}
-	}
-	return err
+	return c.queue.add(d)
 }
 
+// Flush write buffered data to the log file and fsync it
 func (c *SDLogConsumer) Flush() error {
-	sdLogInfo("flush data")
-	var err error = nil
-	c.mutex.Lock()
-	if c.currentFile != nil {
-		err = c.currentFile.Sync()
-	}
-	c.mutex.Unlock()
-	return err
+	c.log.Info("flush data")
+	return c.queue.flush()
 }
 
 func (c *SDLogConsumer) Close() error {
-	sdLogInfo("log consumer close")
-
-	var err error = nil
-	c.mutex.Lock()
-	if c.sdkClose {
-		err = errors.New("[ShimmerData][error]: SDK has been closed")
-	} else {
-		close(c.ch)
-		c.wg.Wait()
-		if c.currentFile != nil {
-			_ = c.currentFile.Sync()
-			err = c.currentFile.Close()
-			c.currentFile = nil
-		}
-	}
-	c.sdkClose = true
-	c.mutex.Unlock()
-	return err
+	c.log.Info("log consumer close")
+	return c.queue.close()
 }
 
 func (c *SDLogConsumer) IsStringent() bool {
 	return false
 }
 
-func (c *SDLogConsumer) constructFileName(timeStr string, i int) string {
-	fileNamePrefix := ""
-	if len(c.fileNamePrefix) != 0 {
-		fileNamePrefix = c.fileNamePrefix + "."
-	}
-	// is need paging
-	if c.fileSize > 0 {
-		return fmt.Sprintf("%s/%slog.%s_%d", c.directory, fileNamePrefix, timeStr, i)
-	} else {
-		return fmt.Sprintf("%s/%slog.%s", c.directory, fileNamePrefix, timeStr)
-	}
+// QueueLen number of events waiting to be written to the log file
+func (c *SDLogConsumer) QueueLen() int {
+	return c.queue.queueLen()
+}
+
+// Dropped number of events dropped because the channel is full
+func (c *SDLogConsumer) Dropped() int64 {
+	return c.queue.dropped.Load()
 }
 
 func (c *SDLogConsumer) init() error {
-	fd, err := c.initLogFile()
+	// compress and clean up rotated files in background
+	c.finishWg.Add(1)
+	go func() {
+		defer c.finishWg.Done()
+		for task := range c.finishCh {
+			c.finishFile(task)
+		}
+	}()
+
+	err := c.initLogFile()
 	if err != nil {
-		sdLogError("init log file failed: %s", err.Error())
+		c.log.Error("init log file failed", "error", err)
+		close(c.finishCh)
+		c.finishWg.Wait()
 		return err
 	}
-	c.currentFile = fd
-
-	c.wg.Add(1)
 
-	go func() {
-		defer func() {
-			c.wg.Done()
-		}()
-		for {
-			select {
-			case rec, ok := <-c.ch:
-				if !ok {
-					return
-				}
-				jsonStr := parseTime(rec)
-				sdLogInfo("write event data: %s", jsonStr)
-				c.writeToFile(string(jsonStr))
-			}
-		}
-	}()
+	c.queue.start()
 
-	sdLogInfo("Mode: log consumer, log path: " + c.directory)
+	c.log.Info("Mode: log consumer", "directory", c.directory)
 
 	return nil
 }
 
-func (c *SDLogConsumer) initLogFile() (*os.File, error) {
-	_, err := os.Stat(c.directory)
-	if err != nil && os.IsNotExist(err) {
-		e := os.MkdirAll(c.directory, os.ModePerm)
-		if e != nil {
-			return nil, e
-		}
-	}
-	timeStr := time.Now().UTC().Format(c.dateFormat)
-	return os.OpenFile(c.constructFileName(timeStr, 0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+func (c *SDLogConsumer) writeLine(line string) {
+	c.writeToFile(line)
 }
 
-var logFileIndex = 0
-
-func (c *SDLogConsumer) writeToFile(str string) {
-	timeStr := time.Now().UTC().Format(c.dateFormat)
-	// paging by Rotate Mode and current file size
-	var newName string
-	fName := c.constructFileName(timeStr, logFileIndex)
-
-	if c.currentFile == nil {
-		var openFileErr error
-		c.currentFile, openFileErr = os.OpenFile(fName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
-		if openFileErr != nil {
-			sdLogInfo("open log file failed: %s\n", openFileErr)
-			return
-		}
-	}
-
-	if c.currentFile.Name() != fName {
-		newName = fName
-	} else if c.fileSize > 0 {
-		stat, _ := c.currentFile.Stat()
-		if stat.Size() > c.fileSize {
-			logFileIndex++
-			newName = c.constructFileName(timeStr, logFileIndex)
-		}
-	}
-	if newName != "" {
-		err := c.currentFile.Close()
-		if err != nil {
-			sdLogInfo("close file failed: %s", err.Error())
-			return
-		}
-		c.currentFile, err = os.OpenFile(fName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
-		if err != nil {
-			sdLogInfo("rotate log file failed: %s", err.Error())
-			return
+func (c *SDLogConsumer) closeSink() error {
+	var err error
+	if c.currentFile != nil {
+		_ = c.sync()
+		err = c.currentFile.Close()
+		// the current file would not be appended after restart in atomic mode
+		if c.atomicRename {
+			c.finishCh <- finishTask{name: c.currentFile.Name()}
 		}
+		c.currentFile = nil
 	}
-	_, err := fmt.Fprintln(c.currentFile, str)
-	if err != nil {
-		sdLogInfo("LoggerWriter(%q): %s", c.currentFile.Name(), err.Error())
-		return
-	}
+	close(c.finishCh)
+	c.finishWg.Wait()
+	return err
 }
@@ -0,0 +1,422 @@
+package shimmerdata
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	inProgressSuffix = ".tmp"  // suffix of the file being written when AtomicRename is enabled
+	doneSuffix       = ".done" // suffix of the manifest of a finished file
+)
+
+// finishTask a rotated file waiting for compression, name is empty if only cleanup is needed
+type finishTask struct {
+	name    string
+	current string // the file being written, never removed by cleanup
+}
+
+// logFile a log file in the directory which belongs to this consumer
+type logFile struct {
+	name       string
+	timeStr    string
+	index      int
+	compressed bool
+	inProgress bool
+	modTime    time.Time
+}
+
+// LogFileManifest content of the ".done" file written after a log file is finished
+type LogFileManifest struct {
+	File  string `json:"file"`  // name of the finished file
+	Lines int64  `json:"lines"` // number of events in the file
+	Size  int64  `json:"size"`  // size of the file in bytes
+	Md5   string `json:"md5"`   // md5 of the file
+}
+
+func (c *SDLogConsumer) constructFileName(timeStr string, i int) string {
+	fileNamePrefix := ""
+	if len(c.fileNamePrefix) != 0 {
+		fileNamePrefix = c.fileNamePrefix + "."
+	}
+	// is need paging, use filepath.Join to keep consistent with listLogFiles.
+	// finished files are never appended in atomic mode, so paging is always needed
+	if c.fileSize > 0 || c.atomicRename {
+		return filepath.Join(c.directory, fmt.Sprintf("%slog.%s_%d", fileNamePrefix, timeStr, i))
+	} else {
+		return filepath.Join(c.directory, fmt.Sprintf("%slog.%s", fileNamePrefix, timeStr))
+	}
+}
+
+// writingFileName name of the file being written
+func (c *SDLogConsumer) writingFileName(timeStr string, i int) string {
+	if c.atomicRename {
+		return c.constructFileName(timeStr, i) + inProgressSuffix
+	}
+	return c.constructFileName(timeStr, i)
+}
+
+// fileNamePattern matches file names created by constructFileName, with optional ".gz" and ".tmp" suffix
+func (c *SDLogConsumer) fileNamePattern() *regexp.Regexp {
+	fileNamePrefix := ""
+	if len(c.fileNamePrefix) != 0 {
+		fileNamePrefix = c.fileNamePrefix + "."
+	}
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(fileNamePrefix) +
+		`log\.(\d{4}-\d{2}-\d{2}(?:-\d{2})?)(?:_(\d+))?(\.gz)?(\.tmp)?$`)
+}
+
+// listLogFiles list log files of this consumer, sorted by modification time, newest first
+func (c *SDLogConsumer) listLogFiles() ([]logFile, error) {
+	entries, err := os.ReadDir(c.directory)
+	if err != nil {
+		return nil, err
+	}
+	var files []logFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := c.namePattern.FindStringSubmatch(entry.Name())
+		// "*.gz.tmp" is being compressed
+		if match == nil || (match[3] != "" && match[4] != "") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		index, _ := strconv.Atoi(match[2])
+		files = append(files, logFile{
+			name:       filepath.Join(c.directory, entry.Name()),
+			timeStr:    match[1],
+			index:      index,
+			compressed: match[3] != "",
+			inProgress: match[4] != "",
+			modTime:    info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	return files, nil
+}
+
+// finished whether the file would not be written any more
+func (c *SDLogConsumer) finished(f logFile) bool {
+	if c.atomicRename {
+		return !f.inProgress
+	}
+	return f.compressed || (c.doneManifest && fileExists(f.name+doneSuffix))
+}
+
+// nextIndex find the paging index to write for timeStr, so that paging continues after restart.
+// the last unfinished file is reused, a finished file is never appended.
+func (c *SDLogConsumer) nextIndex(files []logFile, timeStr string) int {
+	index, finished := -1, false
+	for _, f := range files {
+		if f.timeStr != timeStr {
+			continue
+		}
+		if f.index > index {
+			index, finished = f.index, c.finished(f)
+		} else if f.index == index && c.finished(f) {
+			finished = true
+		}
+	}
+	if index < 0 {
+		return 0
+	}
+	if finished {
+		return index + 1
+	}
+	return index
+}
+
+func (c *SDLogConsumer) initLogFile() error {
+	_, err := os.Stat(c.directory)
+	if err != nil && os.IsNotExist(err) {
+		e := os.MkdirAll(c.directory, os.ModePerm)
+		if e != nil {
+			return e
+		}
+	}
+	files, err := c.listLogFiles()
+	if err != nil {
+		return err
+	}
+	timeStr := time.Now().UTC().Format(c.dateFormat)
+	err = c.openFile(timeStr, c.nextIndex(files, timeStr))
+	if err != nil {
+		return err
+	}
+
+	// files left by last run are finished
+	current := c.currentFile.Name()
+	for _, f := range files {
+		if f.name == current {
+			continue
+		}
+		if f.inProgress || (c.compress && !f.compressed) || (c.doneManifest && !fileExists(f.name+doneSuffix)) {
+			c.finishCh <- finishTask{name: f.name, current: current}
+		}
+	}
+	c.finishCh <- finishTask{current: current}
+	return nil
+}
+
+func (c *SDLogConsumer) openFile(timeStr string, index int) error {
+	name := c.writingFileName(timeStr, index)
+	fd, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+	c.currentFile = fd
+	if c.bufferSize > 0 {
+		if c.writer == nil {
+			c.writer = bufio.NewWriterSize(fd, c.bufferSize)
+		} else {
+			c.writer.Reset(fd)
+		}
+	}
+	c.currentTime = timeStr
+	c.currentIndex = index
+	c.currentSize = stat.Size()
+	return nil
+}
+
+// rotate close current file and open a new one
+func (c *SDLogConsumer) rotate(timeStr string, index int) error {
+	var finished string
+	if c.currentFile != nil {
+		finished = c.currentFile.Name()
+		err := c.sync()
+		if err != nil {
+			return fmt.Errorf("sync file failed: %s", err.Error())
+		}
+		err = c.currentFile.Close()
+		c.currentFile = nil
+		if err != nil {
+			return fmt.Errorf("close file failed: %s", err.Error())
+		}
+	}
+	err := c.openFile(timeStr, index)
+	if err != nil {
+		return err
+	}
+	if finished != "" {
+		c.finishCh <- finishTask{name: finished, current: c.currentFile.Name()}
+	}
+	return nil
+}
+
+func (c *SDLogConsumer) writeToFile(str string) {
+	timeStr := time.Now().UTC().Format(c.dateFormat)
+	// paging by Rotate Mode and current file size
+	var err error
+	if c.currentFile == nil || c.currentTime != timeStr {
+		index := 0
+		if c.currentFile == nil && c.currentTime == timeStr {
+			index = c.currentIndex
+		}
+		err = c.rotate(timeStr, index)
+	} else if c.fileSize > 0 && c.currentSize >= c.fileSize {
+		err = c.rotate(timeStr, c.currentIndex+1)
+	}
+	if err != nil {
+		c.log.Error("rotate log file failed", "error", err)
+		return
+	}
+
+	var out io.Writer = c.currentFile
+	if c.writer != nil {
+		out = c.writer
+	}
+	n, err := fmt.Fprintln(out, str)
+	c.currentSize += int64(n)
+	if err != nil {
+		c.log.Error("write log file failed", "file", c.currentFile.Name(), "error", err)
+		return
+	}
+
+	c.unsynced++
+	switch c.syncPolicy {
+	case SyncAlways:
+		err = c.sync()
+	case SyncEvents:
+		if c.unsynced >= c.syncEvents {
+			err = c.sync()
+		}
+	}
+	if err != nil {
+		c.log.Error("sync log file failed", "file", c.currentFile.Name(), "error", err)
+	}
+}
+
+// flushBuffer write buffered data to os
+func (c *SDLogConsumer) flushBuffer() error {
+	if c.writer == nil || c.currentFile == nil {
+		return nil
+	}
+	return c.writer.Flush()
+}
+
+// sync flush the write buffer and fsync current file if anything is written since last fsync
+func (c *SDLogConsumer) sync() error {
+	if c.currentFile == nil {
+		return nil
+	}
+	err := c.flushBuffer()
+	if err != nil || c.unsynced == 0 {
+		return err
+	}
+	c.unsynced = 0
+	return c.currentFile.Sync()
+}
+
+// finishFile complete a rotated file and remove expired files
+func (c *SDLogConsumer) finishFile(task finishTask) {
+	if task.name != "" {
+		err := c.completeFile(task.name)
+		if err != nil {
+			c.log.Error("finish log file failed", "file", task.name, "error", err)
+		}
+	}
+	c.cleanup(task.current)
+}
+
+// completeFile compress the file, rename it to its final name and write the manifest
+func (c *SDLogConsumer) completeFile(name string) error {
+	final := strings.TrimSuffix(name, inProgressSuffix)
+	if c.compress && codecFromFilename(final) != CodecGzip {
+		final += CodecGzip.Ext()
+		err := transcodeFileTo(name, final, SDCompression{Codec: CodecGzip})
+		if err != nil {
+			return err
+		}
+	} else if final != name {
+		err := os.Rename(name, final)
+		if err != nil {
+			return err
+		}
+	}
+	if c.doneManifest {
+		return writeManifest(final)
+	}
+	return nil
+}
+
+// writeManifest write "<name>.done" for a finished file
+func writeManifest(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	md5Str, err := fileMD5(file)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r, err := NewDecompressReader(file, codecFromFilename(name))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	lines, err := countLines(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(LogFileManifest{
+		File:  filepath.Base(name),
+		Lines: lines,
+		Size:  stat.Size(),
+		Md5:   md5Str,
+	})
+	if err != nil {
+		return err
+	}
+	// the manifest itself is written atomically
+	tmp := name + doneSuffix + inProgressSuffix
+	err = os.WriteFile(tmp, append(data, '\n'), 0664)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name+doneSuffix)
+}
+
+func countLines(r io.Reader) (int64, error) {
+	var lines int64
+	reader := bufio.NewReader(r)
+	for {
+		_, err := reader.ReadSlice('\n')
+		if err == nil {
+			lines++
+			continue
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		return lines, err
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// cleanup remove log files exceeding MaxFiles or MaxAge, the current file is never removed
+func (c *SDLogConsumer) cleanup(current string) {
+	if c.maxFiles <= 0 && c.maxAge <= 0 {
+		return
+	}
+	files, err := c.listLogFiles()
+	if err != nil {
+		c.log.Error("list log files failed", "directory", c.directory, "error", err)
+		return
+	}
+	kept := 1 // the current file
+	for _, f := range files {
+		if f.name == current {
+			continue
+		}
+		expired := c.maxAge > 0 && time.Since(f.modTime) > c.maxAge
+		if !expired && (c.maxFiles <= 0 || kept < c.maxFiles) {
+			kept++
+			continue
+		}
+		err = os.Remove(f.name)
+		if err != nil {
+			c.log.Error("remove log file failed", "file", f.name, "error", err)
+		} else {
+			_ = os.Remove(f.name + doneSuffix)
+			c.log.Info("remove log file", "file", f.name)
+		}
+	}
+}
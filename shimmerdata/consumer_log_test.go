@@ -1,6 +1,13 @@
 package shimmerdata
 
-import "testing"
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestNewLogConsumerWithConfig(t *testing.T) {
 	c, err := NewLogConsumerWithConfig(SDLogConsumerConfig{
@@ -31,3 +38,155 @@ func TestNewLogConsumerWithConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLogConsumerRotate(t *testing.T) {
+	dir := t.TempDir()
+	config := SDLogConsumerConfig{
+		Directory:      dir,
+		RotateMode:     RotateDaily,
+		FileSize:       1,
+		FileNamePrefix: "test",
+		Compress:       true,
+	}
+	c, err := NewLogConsumerWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	payload := strings.Repeat("x", 1024)
+	for i := 0; i < 2000; i++ {
+		err = client.Track("123456", "7890123", "event_name", map[string]interface{}{"payload": payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	timeStr := time.Now().UTC().Format("2006-01-02")
+	for _, name := range []string{"_0.gz", "_1.gz", "_2"} {
+		if _, err = os.Stat(filepath.Join(dir, "test.log."+timeStr+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 重启后继续写入最后一个未压缩的文件，并只保留两个文件
+	config.MaxFiles = 2
+	c, err = NewLogConsumerWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = New(c)
+	if err = client.Track("123456", "7890123", "event_name", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 {
+		t.Fatalf("expect 2 files, got %d", len(files))
+	}
+	if files[0].Name() != "test.log."+timeStr+"_1.gz" || files[1].Name() != "test.log."+timeStr+"_2" {
+		t.Fatalf("unexpected files: %s, %s", files[0].Name(), files[1].Name())
+	}
+}
+
+func TestLogConsumerAtomicRename(t *testing.T) {
+	dir := t.TempDir()
+	config := SDLogConsumerConfig{
+		Directory:    dir,
+		RotateMode:   RotateDaily,
+		AtomicRename: true,
+		DoneManifest: true,
+	}
+	c, err := NewLogConsumerWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	for i := 0; i < 10; i++ {
+		if err = client.Track("123456", "7890123", "event_name", map[string]interface{}{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(dir, "log."+time.Now().UTC().Format("2006-01-02")+"_0")
+	if _, err = os.Stat(name + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("in-progress file should be renamed")
+	}
+	data, err := os.ReadFile(name + ".done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest LogFileManifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Lines != 10 || manifest.File != filepath.Base(name) {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	// 重启后不会追加到已完成的文件
+	c, err = NewLogConsumerWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = os.Stat(name[:len(name)-1] + "1.tmp"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogConsumerSyncPolicy(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewLogConsumerWithConfig(SDLogConsumerConfig{
+		Directory:     dir,
+		RotateMode:    RotateDaily,
+		FlushInterval: time.Hour,
+		SyncPolicy:    SyncEvents,
+		SyncEvents:    5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := New(c)
+	name := filepath.Join(dir, "log."+time.Now().UTC().Format("2006-01-02"))
+	lines := func() int {
+		data, _ := os.ReadFile(name)
+		return strings.Count(string(data), "\n")
+	}
+
+	for i := 0; i < 7; i++ {
+		if err = client.Track("123456", "7890123", "event_name", map[string]interface{}{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 第5条日志写入后同步到磁盘，剩余的日志还在缓冲区中
+	deadline := time.Now().Add(5 * time.Second)
+	for lines() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := lines(); n != 5 {
+		t.Fatalf("expect 5 lines synced, got %d", n)
+	}
+
+	if err = client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := lines(); n != 7 {
+		t.Fatalf("expect 7 lines after flush, got %d", n)
+	}
+
+	if _, err = NewLogConsumerWithConfig(SDLogConsumerConfig{Directory: dir, SyncPolicy: SyncInterval}); err == nil {
+		t.Fatal("SyncInterval policy without interval should be rejected")
+	}
+}
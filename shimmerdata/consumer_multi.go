@@ -0,0 +1,87 @@
+package shimmerdata
+
+import (
+	"context"
+	"errors"
+)
+
+// SDMultiConsumer write data to several consumers, for example to the log file and the HTTP collector at the same time
+type SDMultiConsumer struct {
+	consumers []SDConsumer
+}
+
+// NewMultiConsumer init SDMultiConsumer, every event is added to all consumers in order
+func NewMultiConsumer(consumers ...SDConsumer) (SDConsumer, error) {
+	if len(consumers) == 0 {
+		return nil, errors.New("at least one consumer is required")
+	}
+	for _, c := range consumers {
+		if c == nil {
+			return nil, errors.New("consumer can not be nil")
+		}
+	}
+	return &SDMultiConsumer{consumers: consumers}, nil
+}
+
+// Add add data to all consumers, errors of all consumers are joined
+func (c *SDMultiConsumer) Add(d Data) error {
+	var errs []error
+	for _, consumer := range c.consumers {
+		// consumers may modify properties, every consumer gets its own copy
+		data := d
+		if d.Properties != nil {
+			data.Properties = make(map[string]interface{}, len(d.Properties))
+			mergeProperties(data.Properties, d.Properties)
+		}
+		errs = append(errs, consumer.Add(data))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *SDMultiConsumer) Flush() error {
+	var errs []error
+	for _, consumer := range c.consumers {
+		errs = append(errs, consumer.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// Drain drain consumers with Drain support and flush the others
+func (c *SDMultiConsumer) Drain(ctx context.Context) error {
+	var errs []error
+	for _, consumer := range c.consumers {
+		if d, ok := consumer.(drainer); ok {
+			errs = append(errs, d.Drain(ctx))
+		} else {
+			errs = append(errs, consumer.Flush())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *SDMultiConsumer) Close() error {
+	return c.Shutdown(context.Background())
+}
+
+// Shutdown close all consumers, consumers with deadline support share ctx
+func (c *SDMultiConsumer) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, consumer := range c.consumers {
+		if s, ok := consumer.(interface{ Shutdown(context.Context) error }); ok {
+			errs = append(errs, s.Shutdown(ctx))
+		} else {
+			errs = append(errs, consumer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsStringent data is checked if any consumer requires it
+func (c *SDMultiConsumer) IsStringent() bool {
+	for _, consumer := range c.consumers {
+		if consumer.IsStringent() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package shimmerdata
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SDWriterConsumer write data as NDJSON lines to an io.Writer, such as os.Stdout, a pipe or a socket.
+// it is useful for containers whose stdout is collected by a log agent.
+type SDWriterConsumer struct {
+	out         io.Writer
+	writer      *bufio.Writer // write buffer, nil if buffering is disabled
+	closeWriter bool
+	stringent   bool
+	queue       *lineQueue
+	instanceLog
+}
+
+type SDWriterConsumerConfig struct {
+	ChannelSize    int
+	BufferSize     int               // size of write buffer in bytes, default DefaultBufferSize. negative value disables buffering
+	FlushInterval  time.Duration     // write buffer is flushed to the writer periodically, default DefaultFlushInterval
+	CloseWriter    bool              // close the writer when the consumer is closed, if it implements io.Closer
+	Stringent      bool              // check property keys strictly, useful while debugging
+	FullPolicy     ChannelFullPolicy // what Add does when the channel is full, default ChannelFullBlock
+	EnqueueTimeout time.Duration     // max time Add waits for ChannelFullTimeout policy
+}
+
+// NewWriterConsumer init SDWriterConsumer, events are written to w one JSON object per line
+func NewWriterConsumer(w io.Writer, config SDWriterConsumerConfig, opts ...Option) (SDConsumer, error) {
+	o := newOptions(opts)
+	if w == nil {
+		errStr := "writer can not be nil"
+		o.logger.Info(errStr)
+		return nil, errors.New(errStr)
+	}
+	bufferSize := config.BufferSize
+	if bufferSize == 0 {
+		bufferSize = DefaultBufferSize
+	}
+	c := &SDWriterConsumer{
+		out:         w,
+		closeWriter: config.CloseWriter,
+		stringent:   config.Stringent,
+		instanceLog: o.instanceLog(),
+	}
+	if bufferSize > 0 {
+		c.writer = bufio.NewWriterSize(w, bufferSize)
+	}
+	var err error
+	c.queue, err = newLineQueue(c, lineQueueConfig{
+		chanSize:      config.ChannelSize,
+		flushInterval: config.FlushInterval,
+		fullPolicy:    config.FullPolicy,
+		timeout:       config.EnqueueTimeout,
+		log:           o.logger,
+	})
+	if err != nil {
+		o.logger.Info(err.Error())
+		return nil, err
+	}
+	c.queue.start()
+	o.logger.Info("Mode: writer consumer")
+	return c, nil
+}
+
+func (c *SDWriterConsumer) Add(d Data) error {
+	return c.queue.add(d)
+}
+
+// Flush write buffered data to the writer
+func (c *SDWriterConsumer) Flush() error {
+	c.log.Info("flush data")
+	return c.queue.flush()
+}
+
+func (c *SDWriterConsumer) Close() error {
+	c.log.Info("writer consumer close")
+	return c.queue.close()
+}
+
+func (c *SDWriterConsumer) IsStringent() bool {
+	return c.stringent
+}
+
+// QueueLen number of events waiting to be written to the writer
+func (c *SDWriterConsumer) QueueLen() int {
+	return c.queue.queueLen()
+}
+
+// Dropped number of events dropped because the channel is full
+func (c *SDWriterConsumer) Dropped() int64 {
+	return c.queue.dropped.Load()
+}
+
+func (c *SDWriterConsumer) writeLine(line string) {
+	var out = c.out
+	if c.writer != nil {
+		out = c.writer
+	}
+	_, err := fmt.Fprintln(out, line)
+	if err != nil {
+		c.log.Error("write event failed", "error", err)
+	}
+}
+
+func (c *SDWriterConsumer) flushBuffer() error {
+	if c.writer == nil {
+		return nil
+	}
+	return c.writer.Flush()
+}
+
+// sync flush the write buffer, the writer is not fsynced since it may be a pipe or socket
+func (c *SDWriterConsumer) sync() error {
+	return c.flushBuffer()
+}
+
+func (c *SDWriterConsumer) closeSink() error {
+	err := c.flushBuffer()
+	if closer, ok := c.out.(io.Closer); ok && c.closeWriter {
+		closeErr := closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package shimmerdata
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriterConsumer(t *testing.T) {
+	r, w := io.Pipe()
+	c, err := NewWriterConsumer(w, SDWriterConsumerConfig{CloseWriter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan []string)
+	go func() {
+		var got []string
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		lines <- got
+	}()
+
+	client := New(c)
+	for i := 0; i < 10; i++ {
+		err = client.Track("123456", "7890123", "event_name", map[string]interface{}{"index": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	if len(got) != 10 {
+		t.Fatalf("got %d lines, want 10", len(got))
+	}
+	for i, line := range got {
+		var d Data
+		err = json.Unmarshal([]byte(line), &d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.EventName != "event_name" || d.Properties["index"] != float64(i) {
+			t.Fatalf("unexpected line %d: %s", i, line)
+		}
+	}
+	if client.Track("123456", "7890123", "event_name", nil) == nil {
+		t.Fatal("add after close should fail")
+	}
+}
+
+func TestWriterConsumerFullPolicy(t *testing.T) {
+	for _, policy := range []ChannelFullPolicy{ChannelFullDrop, ChannelFullTimeout} {
+		r, w := io.Pipe()
+		c, err := NewWriterConsumer(w, SDWriterConsumerConfig{
+			ChannelSize:    1,
+			BufferSize:     -1,
+			CloseWriter:    true,
+			FullPolicy:     policy,
+			EnqueueTimeout: 50 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		consumer := c.(*SDWriterConsumer)
+		client := New(c)
+
+		// the first event blocks the writing goroutine since nobody reads the pipe
+		err = client.Track("123456", "7890123", "event_name", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for consumer.QueueLen() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		// the second event fills the channel
+		err = client.Track("123456", "7890123", "event_name", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if consumer.QueueLen() != 1 {
+			t.Fatalf("got queue length %d, want 1", consumer.QueueLen())
+		}
+		err = client.Track("123456", "7890123", "event_name", nil)
+		if !errors.Is(err, ErrChannelFull) {
+			t.Fatalf("got %v, want ErrChannelFull", err)
+		}
+		if consumer.Dropped() != 1 {
+			t.Fatalf("got %d dropped, want 1", consumer.Dropped())
+		}
+
+		lines := make(chan int)
+		go func() {
+			n := 0
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				n++
+			}
+			lines <- n
+		}()
+		err = client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := <-lines; n != 2 {
+			t.Fatalf("got %d lines, want 2", n)
+		}
+	}
+}
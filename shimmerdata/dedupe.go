@@ -0,0 +1,99 @@
+package shimmerdata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// dedupeFalsePositive 去重过滤器的误判率，误判会导致新日志被当作重复日志丢弃，所以取值很小
+const dedupeFalsePositive = 1e-6
+
+// batchIdOf 根据批次内所有日志的#uuid生成批次ID。内容相同的批次ID相同，重试时服务端可以据此去重
+func batchIdOf(uuids []string) string {
+	h := sha256.New()
+	for _, id := range uuids {
+		h.Write([]byte(id))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// uuidFilter 记录最近发送成功的#uuid的布隆过滤器。
+// 使用两代过滤器轮换：当前代写满capacity后成为上一代，上一代被丢弃，因此只保留最近 capacity~2*capacity 条记录。
+type uuidFilter struct {
+	capacity int
+	bits     uint64 // 每一代的位数
+	hashes   int    // 每个元素的哈希次数
+	current  []uint64
+	previous []uint64
+	count    int // 当前代已写入的个数
+	mutex    sync.Mutex
+}
+
+func newUUIDFilter(capacity int) *uuidFilter {
+	bits := uint64(math.Ceil(-float64(capacity) * math.Log(dedupeFalsePositive) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Ceil(float64(bits) / float64(capacity) * math.Ln2))
+	words := (bits + 63) / 64
+	return &uuidFilter{
+		capacity: capacity,
+		bits:     words * 64,
+		hashes:   hashes,
+		current:  make([]uint64, words),
+		previous: make([]uint64, words),
+	}
+}
+
+// Add 记录已发送成功的#uuid
+func (f *uuidFilter) Add(uuids ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, id := range uuids {
+		if f.count >= f.capacity {
+			f.previous, f.current = f.current, f.previous
+			for i := range f.current {
+				f.current[i] = 0
+			}
+			f.count = 0
+		}
+		h1, h2 := f.hash(id)
+		for i := 0; i < f.hashes; i++ {
+			pos := (h1 + uint64(i)*h2) % f.bits
+			f.current[pos/64] |= 1 << (pos % 64)
+		}
+		f.count++
+	}
+}
+
+// Contains #uuid是否最近发送成功过
+func (f *uuidFilter) Contains(id string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	h1, h2 := f.hash(id)
+	return f.test(f.current, h1, h2) || f.test(f.previous, h1, h2)
+}
+
+func (f *uuidFilter) test(set []uint64, h1, h2 uint64) bool {
+	for i := 0; i < f.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % f.bits
+		if set[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash 双重哈希，h2为奇数保证遍历不同的位置
+func (f *uuidFilter) hash(id string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(id))
+	sum := h.Sum(nil)
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+	return h1, h2 | 1
+}
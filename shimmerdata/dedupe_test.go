@@ -0,0 +1,44 @@
+package shimmerdata
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUUIDFilter(t *testing.T) {
+	f := newUUIDFilter(1000)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("uuid-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Contains(fmt.Sprintf("uuid-%d", i)) {
+			t.Fatalf("uuid-%d should be found", i)
+		}
+	}
+	for i := 1000; i < 2000; i++ {
+		if f.Contains(fmt.Sprintf("uuid-%d", i)) {
+			t.Fatalf("uuid-%d should not be found", i)
+		}
+	}
+
+	// 写满两代后最早的记录被淘汰
+	for i := 1000; i < 3000; i++ {
+		f.Add(fmt.Sprintf("uuid-%d", i))
+	}
+	if f.Contains("uuid-0") {
+		t.Fatal("uuid-0 should be expired")
+	}
+	if !f.Contains("uuid-2999") {
+		t.Fatal("uuid-2999 should be found")
+	}
+}
+
+func TestBatchIdOf(t *testing.T) {
+	a := batchIdOf([]string{"1", "2", "3"})
+	if a != batchIdOf([]string{"1", "2", "3"}) {
+		t.Fatal("batch id should be stable")
+	}
+	if a == batchIdOf([]string{"1", "23"}) {
+		t.Fatal("different batches should have different ids")
+	}
+}
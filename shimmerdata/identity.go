@@ -0,0 +1,209 @@
+package shimmerdata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// BindEventName event sent by BindAccount, the backend merges the guest and the account by events
+// carrying both #account_id and #distinct_id
+const BindEventName = "account_bind"
+
+// ErrIdentityBound the guest has been bound to another account
+var ErrIdentityBound = errors.New("distinct id has been bound to another account")
+
+// IdentityStore mapping between guests (#distinct_id) and accounts (#account_id), see WithIdentityStore.
+// a guest is bound to one account, an account may be bound by guests of several devices.
+type IdentityStore interface {
+	AccountId(distinctId string) (string, error) // account of the guest, empty if not bound
+	DistinctId(accountId string) (string, error) // the guest bound last to the account, empty if not bound
+	Bind(distinctId, accountId string) error     // returns ErrIdentityBound if the guest is bound to another account
+}
+
+// MemoryIdentityStore IdentityStore in memory, lost when the process exits
+type MemoryIdentityStore struct {
+	accounts  map[string]string // distinct id -> account id
+	distincts map[string]string // account id -> distinct id
+	mutex     sync.RWMutex
+}
+
+// NewMemoryIdentityStore create an empty MemoryIdentityStore
+func NewMemoryIdentityStore() *MemoryIdentityStore {
+	return &MemoryIdentityStore{
+		accounts:  make(map[string]string),
+		distincts: make(map[string]string),
+	}
+}
+
+func (s *MemoryIdentityStore) AccountId(distinctId string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.accounts[distinctId], nil
+}
+
+func (s *MemoryIdentityStore) DistinctId(accountId string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.distincts[accountId], nil
+}
+
+func (s *MemoryIdentityStore) Bind(distinctId, accountId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bind(distinctId, accountId)
+}
+
+func (s *MemoryIdentityStore) bind(distinctId, accountId string) error {
+	if old, ok := s.accounts[distinctId]; ok && old != accountId {
+		return ErrIdentityBound
+	}
+	s.accounts[distinctId] = accountId
+	s.distincts[accountId] = distinctId
+	return nil
+}
+
+// FileIdentityStore IdentityStore kept in memory and appended to a file, one json object per binding:
+//
+//	{"distinct_id":"guest","account_id":"account"}
+type FileIdentityStore struct {
+	*MemoryIdentityStore
+	file *os.File
+}
+
+type identityRecord struct {
+	DistinctId string `json:"distinct_id"`
+	AccountId  string `json:"account_id"`
+}
+
+// NewFileIdentityStore load the bindings of path and append new ones to it, the file is created if not exists
+func NewFileIdentityStore(path string) (*FileIdentityStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileIdentityStore{MemoryIdentityStore: NewMemoryIdentityStore(), file: file}
+	err = readLines(file, func(n int, line []byte) error {
+		var r identityRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		// later records win, Bind never writes a guest bound to another account
+		s.accounts[r.DistinctId] = r.AccountId
+		s.distincts[r.AccountId] = r.DistinctId
+		return nil
+	})
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Bind append the binding to the file first, the binding in memory is not changed if the write fails
+func (s *FileIdentityStore) Bind(distinctId, accountId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.accounts[distinctId] == accountId && s.distincts[accountId] == distinctId {
+		return nil
+	}
+	if old, ok := s.accounts[distinctId]; ok && old != accountId {
+		return ErrIdentityBound
+	}
+	line, err := json.Marshal(identityRecord{DistinctId: distinctId, AccountId: accountId})
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.bind(distinctId, accountId)
+}
+
+// Close close the file
+func (s *FileIdentityStore) Close() error {
+	return s.file.Close()
+}
+
+// WithIdentityStore fill the missing #account_id or #distinct_id of events by the bindings of store,
+// bindings are added by SDAnalytics.BindAccount
+func WithIdentityStore(store IdentityStore) Option {
+	return func(o *options) {
+		o.identityStore = store
+	}
+}
+
+// BindAccount bind the guest to the account after the player logs in. the events are sent after the binding
+// is saved: the first time the guest is bound, a user_setOnce of first_bind_time and a BindEventName event
+// with properties are sent, both carrying the two ids. binding again to the same account sends nothing.
+// it returns ErrIdentityBound if the guest is bound to another account, also when BindAccount of the same
+// guest is called concurrently with different accounts and the other one wins.
+func (ta *SDAnalytics) BindAccount(accountId, distinctId string, properties map[string]interface{}) error {
+	if len(accountId) == 0 || len(distinctId) == 0 {
+		msg := "invalid params for BindAccount: account_id and distinct_id must be provided"
+		ta.log.Info(msg)
+		return errors.New(msg)
+	}
+	store := ta.identityStore
+	if store == nil {
+		msg := "BindAccount requires WithIdentityStore"
+		ta.log.Info(msg)
+		return errors.New(msg)
+	}
+	// Bind is the conflict check, the lookup before it only tells whether the guest is bound for the first time
+	ta.bindMutex.Lock()
+	old, err := store.AccountId(distinctId)
+	if err != nil {
+		ta.bindMutex.Unlock()
+		ta.log.Error("load identity failed", "distinctId", distinctId, "error", err)
+		return err
+	}
+	err = store.Bind(distinctId, accountId)
+	ta.bindMutex.Unlock()
+	if errors.Is(err, ErrIdentityBound) {
+		ta.log.Info("distinct id has been bound", "distinctId", distinctId, "newAccountId", accountId)
+		return err
+	}
+	if err != nil {
+		ta.log.Error("save identity failed", "distinctId", distinctId, "accountId", accountId, "error", err)
+		return err
+	}
+	if old == accountId {
+		// the guest logs in again, it has become the latest guest of the account
+		return nil
+	}
+
+	ta.log.Debug("account bound", "distinctId", distinctId, "accountId", accountId)
+	err = ta.UserSetOnce(accountId, distinctId, map[string]interface{}{"first_bind_time": time.Now().UTC().Format(DATE_FORMAT)})
+	if err != nil {
+		return err
+	}
+	return ta.Track(accountId, distinctId, BindEventName, properties)
+}
+
+// resolveIdentity fill the missing id by the identity store, ids are returned unchanged if lookup fails
+func (ta *SDAnalytics) resolveIdentity(accountId, distinctId string) (string, string) {
+	store := ta.identityStore
+	if store == nil || len(accountId) > 0 && len(distinctId) > 0 {
+		return accountId, distinctId
+	}
+	var err error
+	if len(accountId) == 0 && len(distinctId) > 0 {
+		var id string
+		if id, err = store.AccountId(distinctId); err == nil {
+			accountId = id
+		}
+	} else if len(distinctId) == 0 && len(accountId) > 0 {
+		var id string
+		if id, err = store.DistinctId(accountId); err == nil {
+			distinctId = id
+		}
+	}
+	if err != nil {
+		ta.log.Warn("load identity failed", "accountId", accountId, "distinctId", distinctId, "error", err)
+	}
+	return accountId, distinctId
+}
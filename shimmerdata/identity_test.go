@@ -0,0 +1,158 @@
+package shimmerdata
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBindAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.log")
+	store, err := NewFileIdentityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := &memoryConsumer{}
+	ta := New(consumer, WithIdentityStore(store))
+
+	if err := ta.BindAccount("account", "guest", map[string]interface{}{"channel": "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(consumer.data) != 2 {
+		t.Fatalf("expect user_setOnce and bind event, got %d", len(consumer.data))
+	}
+	for _, d := range consumer.data {
+		if d.AccountId != "account" || d.DistinctId != "guest" {
+			t.Fatalf("bind events should carry both ids: %+v", d)
+		}
+	}
+	if consumer.data[0].Type != UserSetOnce || consumer.data[1].EventName != BindEventName || consumer.data[1].Properties["channel"] != "web" {
+		t.Fatalf("unexpected bind events: %+v", consumer.data)
+	}
+
+	// 重复绑定不发送日志，绑定其他账号返回错误
+	if err := ta.BindAccount("account", "guest", nil); err != nil || len(consumer.data) != 2 {
+		t.Fatalf("bind again: %v, %d events", err, len(consumer.data))
+	}
+	if err := ta.BindAccount("other", "guest", nil); !errors.Is(err, ErrIdentityBound) {
+		t.Fatalf("expect ErrIdentityBound, got %v", err)
+	}
+
+	// 缺少的ID自动补全
+	if err := ta.Track("", "guest", "login", nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := consumer.data[2]; d.AccountId != "account" || d.DistinctId != "guest" {
+		t.Fatalf("account id should be resolved: %+v", d)
+	}
+	if err := ta.UserSet("account", "", map[string]interface{}{"level": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if d := consumer.data[3]; d.AccountId != "account" || d.DistinctId != "guest" {
+		t.Fatalf("distinct id should be resolved: %+v", d)
+	}
+	if err := ta.Track("", "stranger", "login", nil); err != nil || consumer.data[4].AccountId != "" {
+		t.Fatalf("unbound guest: %v, %+v", err, consumer.data[4])
+	}
+
+	// 新设备登录后成为账号最新的访客
+	if err := ta.BindAccount("account", "guest2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开时加载已有的绑定
+	store, err = NewFileIdentityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if id, _ := store.AccountId("guest"); id != "account" {
+		t.Fatalf("unexpected account id of guest: %s", id)
+	}
+	if id, _ := store.DistinctId("account"); id != "guest2" {
+		t.Fatalf("unexpected distinct id of account: %s", id)
+	}
+
+	if err := New(consumer).BindAccount("account", "guest", nil); err == nil {
+		t.Fatal("BindAccount requires an identity store")
+	}
+}
+
+func TestFileIdentityStoreWriteFailed(t *testing.T) {
+	store, err := NewFileIdentityStore(filepath.Join(t.TempDir(), "identity.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件写入失败时不保留内存中的绑定，再次绑定时重新写入
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = store.Bind("guest", "account"); err == nil {
+			t.Fatal("Bind should fail when the file can not be written")
+		}
+	}
+	if id, _ := store.AccountId("guest"); id != "" {
+		t.Fatalf("binding should not be kept after the write failed: %s", id)
+	}
+	if id, _ := store.DistinctId("account"); id != "" {
+		t.Fatalf("binding should not be kept after the write failed: %s", id)
+	}
+}
+
+func TestBindAccountConcurrent(t *testing.T) {
+	consumer := &syncConsumer{}
+	ta := New(consumer, WithIdentityStore(NewMemoryIdentityStore()))
+
+	// 同一个访客同时绑定不同的账号，只有一个成功并发送日志
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = ta.BindAccount(fmt.Sprintf("account%d", i), "guest", nil)
+		}(i)
+	}
+	wg.Wait()
+	winner := ""
+	for i, err := range errs {
+		if err == nil {
+			if winner != "" {
+				t.Fatalf("guest bound to both %s and account%d", winner, i)
+			}
+			winner = fmt.Sprintf("account%d", i)
+		} else if !errors.Is(err, ErrIdentityBound) {
+			t.Fatalf("expect ErrIdentityBound, got %v", err)
+		}
+	}
+	events := consumer.events()
+	if winner == "" || len(events) != 2 {
+		t.Fatalf("expect one binding with 2 events, got %q with %d events", winner, len(events))
+	}
+	for _, d := range events {
+		if d.AccountId != winner || d.DistinctId != "guest" {
+			t.Fatalf("bind events should carry the bound account: %+v", d)
+		}
+	}
+
+	// 同时重复绑定同一个账号也只发送一次
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ta.BindAccount("account", "guest2", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(consumer.events()); n != 4 {
+		t.Fatalf("expect 2 more events, got %d", n-2)
+	}
+}
@@ -0,0 +1,566 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImportFormat format of the source of Import
+type ImportFormat string
+
+const (
+	ImportNDJSON ImportFormat = "ndjson" // one JSON object per line
+	ImportCSV    ImportFormat = "csv"    // comma separated values with a header line, ImportConfig.Mapping is required
+)
+
+// DefaultCheckpointEvery default number of events between two checkpoints
+const DefaultCheckpointEvery = 1000
+
+// ImportMapping maps the fields of flat source records to Data. Mapped values of #account_id, #distinct_id,
+// #type, #event_name and #event_id are converted to strings; #time, #uuid, #ip, #app_id and #first_check_id
+// are handled like the same keys in the properties of Track. Empty CSV values are treated as missing.
+type ImportMapping struct {
+	Type       string            `json:"type" yaml:"type"`               // #type of records without a column mapped to #type, default track
+	EventName  string            `json:"event_name" yaml:"event_name"`   // #event_name of records without a column mapped to #event_name
+	Columns    map[string]string `json:"columns" yaml:"columns"`         // source field -> reserved key such as #time, or property name. "-" drops the field
+	Types      map[string]string `json:"types" yaml:"types"`             // source field -> number, bool, string, time or json. string values are converted, default string
+	TimeFormat string            `json:"time_format" yaml:"time_format"` // Go layout of #time and time fields, or unix / unix_ms for timestamps. default DATE_FORMAT
+	TimeZone   string            `json:"time_zone" yaml:"time_zone"`     // location of times without zone, default UTC
+	DropOthers bool              `json:"drop_others" yaml:"drop_others"` // drop fields not in Columns instead of importing them as properties
+
+	location *time.Location
+}
+
+// ImportConfig options of Import
+type ImportConfig struct {
+	Format          ImportFormat              // format of the source, default ImportNDJSON
+	Mapping         *ImportMapping            // required by ImportCSV. without it NDJSON lines are events in shimmerdata or ThinkingData format, see parseNDJSON
+	Rate            float64                   // max events per second, 0 means no limit
+	Checkpoint      string                    // file to save the progress, Import with the same Checkpoint resumes after the last saved line
+	CheckpointEvery int                       // save the progress every CheckpointEvery events if Checkpoint is set, default DefaultCheckpointEvery
+	SkipInvalid     bool                      // skip invalid records instead of stopping the import
+	OnInvalid       func(line int, err error) // called for every invalid record when SkipInvalid is set
+}
+
+// ImportResult statistics of Import
+type ImportResult struct {
+	Lines    int // last line read from the source
+	Resumed  int // records skipped because they were imported before the checkpoint
+	Imported int // records added to the consumer
+	Invalid  int // records skipped because they are invalid
+}
+
+// importRecord one record of the source, properties contain reserved keys such as #time
+type importRecord struct {
+	accountId, distinctId, dataType, eventName, eventId string
+	properties                                          map[string]interface{}
+}
+
+// importCheckpoint content of ImportConfig.Checkpoint
+type importCheckpoint struct {
+	Name string `json:"name"` // name of the source
+	Line int    `json:"line"` // all records up to this line have been imported or skipped
+}
+
+// LoadImportMapping read ImportMapping from a JSON (".json") or YAML (".yaml", ".yml") file
+func LoadImportMapping(path string) (*ImportMapping, error) {
+	m := &ImportMapping{}
+	err := decodeFile(path, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, m.init()
+}
+
+func (m *ImportMapping) init() error {
+	m.location = time.UTC
+	if m.TimeZone != "" {
+		location, err := time.LoadLocation(m.TimeZone)
+		if err != nil {
+			return fmt.Errorf("time_zone: %w", err)
+		}
+		m.location = location
+	}
+	for field, t := range m.Types {
+		switch t {
+		case "number", "bool", "string", "time", "json":
+		default:
+			return fmt.Errorf("types.%s: unknown type %q, should be number, bool, string, time or json", field, t)
+		}
+	}
+	return nil
+}
+
+// ImportFile import events from a file by Import, the file is decompressed by its extension such as ".gz".
+// the format is detected by the extension when ImportConfig.Format is empty. the name of the source is the
+// absolute path, files with the same base name in different directories get different #uuid and checkpoints.
+func (ta *SDAnalytics) ImportFile(ctx context.Context, path string, config ImportConfig) (ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer file.Close()
+	codec := codecFromFilename(path)
+	r, err := NewDecompressReader(file, codec)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer r.Close()
+	name, err := filepath.Abs(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if config.Format == "" && strings.EqualFold(filepath.Ext(strings.TrimSuffix(path, codec.Ext())), ".csv") {
+		config.Format = ImportCSV
+	}
+	return ta.Import(ctx, r, name, config)
+}
+
+// Import add historical events from r to the consumer. every record goes through the same checks as Track,
+// #time of the source is kept. records without #uuid get one derived from name and the line, so events sent
+// again after resuming can be deduplicated by SDBatchConfig.DedupeSize or the server. name identifies the
+// source, it must be unique among sources, e.g. the absolute path of a file.
+// the progress is saved after every added event is sent or written to SDBatchConfig.TempDir, events after
+// the last checkpoint may be added again.
+func (ta *SDAnalytics) Import(ctx context.Context, r io.Reader, name string, config ImportConfig) (ImportResult, error) {
+	var result ImportResult
+	if config.Format == "" {
+		config.Format = ImportNDJSON
+	}
+	if config.Format != ImportNDJSON && config.Format != ImportCSV {
+		return result, fmt.Errorf("unknown import format: %s", config.Format)
+	}
+	if config.Format == ImportCSV && config.Mapping == nil {
+		return result, errors.New("mapping is required by csv format")
+	}
+	if config.Mapping != nil && config.Mapping.location == nil {
+		if err := config.Mapping.init(); err != nil {
+			return result, err
+		}
+	}
+	if config.CheckpointEvery <= 0 {
+		config.CheckpointEvery = DefaultCheckpointEvery
+	}
+	resume, err := loadCheckpoint(config.Checkpoint, name)
+	if err != nil {
+		return result, err
+	}
+	limiter := newRateLimiter(config.Rate)
+
+	// done is the last line of which the record has been handled
+	done := resume
+	sinceCheckpoint := 0
+	// events may have been dropped if a checkpoint failed, the progress is not saved any more
+	var checkpointErr error
+	handle := func(line int, parse func() (importRecord, error)) error {
+		result.Lines = line
+		if line <= resume {
+			result.Resumed++
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := ta.importData(parse, name, line)
+		if err != nil {
+			if !config.SkipInvalid {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			result.Invalid++
+			if config.OnInvalid != nil {
+				config.OnInvalid(line, err)
+			}
+		} else {
+			if err := limiter.wait(ctx); err != nil {
+				return err
+			}
+			if err := ta.consumer.Add(data); err != nil {
+				return err
+			}
+			result.Imported++
+		}
+		done = line
+		sinceCheckpoint++
+		// without Checkpoint the consumer is only drained once at the end
+		if config.Checkpoint != "" && sinceCheckpoint >= config.CheckpointEvery {
+			sinceCheckpoint = 0
+			checkpointErr = ta.saveCheckpoint(ctx, config.Checkpoint, name, done)
+			return checkpointErr
+		}
+		return nil
+	}
+
+	if config.Format == ImportCSV {
+		err = readCSV(r, config.Mapping, handle)
+	} else {
+		err = readLines(r, func(n int, line []byte) error {
+			// batch markers of spool files of SDBatchConsumer
+			if _, ok := parseSpoolMarker(line); ok {
+				return nil
+			}
+			return handle(n, func() (importRecord, error) {
+				return parseNDJSON(line, config.Mapping)
+			})
+		})
+	}
+	if done > resume && checkpointErr == nil {
+		// the progress is saved even if ctx is canceled
+		if saveErr := ta.saveCheckpoint(context.WithoutCancel(ctx), config.Checkpoint, name, done); err == nil {
+			err = saveErr
+		}
+	}
+	ta.log.Info("import finished", "name", name, "lines", result.Lines, "resumed", result.Resumed,
+		"imported", result.Imported, "invalid", result.Invalid, "error", err)
+	return result, err
+}
+
+// importData check the record and build the event like Track does, without super properties and #lib
+func (ta *SDAnalytics) importData(parse func() (importRecord, error), name string, line int) (Data, error) {
+	r, err := parse()
+	if err != nil {
+		return Data{}, err
+	}
+	if !dataTypes[r.dataType] {
+		return Data{}, fmt.Errorf("unknown #type %q", r.dataType)
+	}
+	switch r.dataType {
+	case Track, TrackUpdate, TrackOverwrite:
+		if r.eventName == "" {
+			return Data{}, errors.New("the event name must be provided")
+		}
+		if r.dataType != Track && r.eventId == "" {
+			return Data{}, errors.New("the event id must be provided")
+		}
+	}
+	// the time of historical events must come from the source
+	if _, ok := r.properties["#time"]; !ok {
+		return Data{}, errors.New("#time must be provided")
+	}
+	// #lib of the SDK which wrote the log is kept
+	if err := ta.checkPresetKeys(r.properties, true); err != nil {
+		return Data{}, err
+	}
+	if _, ok := r.properties["#uuid"]; !ok {
+		r.properties["#uuid"] = uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("%s:%d", name, line))).String()
+	}
+	return ta.newData(r.accountId, r.distinctId, r.dataType, r.eventName, r.eventId, r.properties)
+}
+
+// parseNDJSON parse a line in shimmerdata format, or a flat object mapped by m. without m #time is UTC,
+// unless the line has #zone_offset: ThinkingData writes #time in the local time of the writer with its
+// offset from UTC in hours, such #time is converted to UTC.
+func parseNDJSON(line []byte, m *ImportMapping) (importRecord, error) {
+	if m != nil {
+		values := make(map[string]interface{})
+		if err := decodeJSON(line, &values); err != nil {
+			return importRecord{}, err
+		}
+		return m.record(values)
+	}
+	var d Data
+	if err := decodeJSON(line, &d); err != nil {
+		return importRecord{}, err
+	}
+	p := make(map[string]interface{}, len(d.Properties)+5)
+	mergeProperties(p, d.Properties)
+	for k, v := range map[string]string{"#time": d.Time, "#uuid": d.UUID, "#ip": d.Ip, "#app_id": d.AppId, "#first_check_id": d.FirstCheckId} {
+		if v != "" {
+			p[k] = v
+		}
+	}
+	if offset, ok := p["#zone_offset"].(json.Number); ok && d.Time != "" {
+		hours, err := offset.Float64()
+		if err != nil {
+			return importRecord{}, fmt.Errorf("invalid #zone_offset %s", offset)
+		}
+		t, err := time.ParseInLocation(DATE_FORMAT, d.Time, time.FixedZone("", int(hours*3600)))
+		if err != nil {
+			return importRecord{}, fmt.Errorf("#time format should be %s", DATE_FORMAT)
+		}
+		p["#time"] = t
+	}
+	return importRecord{
+		accountId:  d.AccountId,
+		distinctId: d.DistinctId,
+		dataType:   d.Type,
+		eventName:  d.EventName,
+		eventId:    d.EventId,
+		properties: p,
+	}, nil
+}
+
+// decodeJSON unmarshal data with numbers as json.Number, ids and integers above 2^53 are kept exactly
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after the JSON value")
+	}
+	return nil
+}
+
+// readCSV read records after the header line, handle is called with the line of every record
+func readCSV(r io.Reader, m *ImportMapping, handle func(line int, parse func() (importRecord, error)) error) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("read csv header failed: %w", err)
+	}
+	header = append([]string(nil), header...)
+	reader.FieldsPerRecord = len(header)
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		err = handle(line, func() (importRecord, error) {
+			values := make(map[string]interface{}, len(fields))
+			for i, v := range fields {
+				if v != "" {
+					values[header[i]] = v
+				}
+			}
+			return m.record(values)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// record map values of the source to a record
+func (m *ImportMapping) record(values map[string]interface{}) (importRecord, error) {
+	r := importRecord{
+		dataType:   m.Type,
+		eventName:  m.EventName,
+		properties: make(map[string]interface{}, len(values)),
+	}
+	if r.dataType == "" {
+		r.dataType = Track
+	}
+	for field, v := range values {
+		target, ok := m.Columns[field]
+		if !ok {
+			if m.DropOthers {
+				continue
+			}
+			target = field
+		}
+		if target == "-" {
+			continue
+		}
+		v, err := m.convert(field, target, v)
+		if err != nil {
+			return importRecord{}, fmt.Errorf("%s: %w", field, err)
+		}
+		switch target {
+		case "#account_id":
+			r.accountId = toString(v)
+		case "#distinct_id":
+			r.distinctId = toString(v)
+		case "#type":
+			r.dataType = toString(v)
+		case "#event_name":
+			r.eventName = toString(v)
+		case "#event_id":
+			r.eventId = toString(v)
+		default:
+			r.properties[target] = v
+		}
+	}
+	return r, nil
+}
+
+// convert convert the value of field by Types, #time is always parsed by TimeFormat
+func (m *ImportMapping) convert(field, target string, v interface{}) (interface{}, error) {
+	t := m.Types[field]
+	if target == "#time" {
+		t = "time"
+	}
+	s, isString := v.(string)
+	switch t {
+	case "time":
+		return m.parseTime(v)
+	case "number":
+		if !isString {
+			return v, nil
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		if !isString {
+			return v, nil
+		}
+		return strconv.ParseBool(s)
+	case "json":
+		if !isString {
+			return v, nil
+		}
+		var value interface{}
+		return value, decodeJSON([]byte(s), &value)
+	}
+	return v, nil
+}
+
+// parseTime parse a time by TimeFormat. without TimeFormat strings are kept and checked as DATE_FORMAT later
+func (m *ImportMapping) parseTime(v interface{}) (interface{}, error) {
+	switch m.TimeFormat {
+	case "unix", "unix_ms":
+		var n float64
+		switch value := v.(type) {
+		case json.Number:
+			var err error
+			n, err = value.Float64()
+			if err != nil {
+				return nil, err
+			}
+		case string:
+			var err error
+			n, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid timestamp %v", v)
+		}
+		if m.TimeFormat == "unix_ms" {
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.UnixMilli(int64(n * 1000)), nil
+	case "":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid time %v, set time_format for timestamps", v)
+		}
+		if m.location != time.UTC {
+			return time.ParseInLocation(DATE_FORMAT, s, m.location)
+		}
+		return s, nil
+	default:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid time %v", v)
+		}
+		return time.ParseInLocation(m.TimeFormat, s, m.location)
+	}
+}
+
+func toString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// loadCheckpoint the line to resume after, 0 if there is no checkpoint
+func loadCheckpoint(path, name string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var c importCheckpoint
+	if err = json.Unmarshal(data, &c); err != nil {
+		return 0, fmt.Errorf("parse checkpoint %s failed: %w", path, err)
+	}
+	if c.Name != name {
+		return 0, fmt.Errorf("checkpoint %s belongs to %s, not %s", path, c.Name, name)
+	}
+	return c.Line, nil
+}
+
+// drainer consumers of which Flush returns before events are sent, Drain returns after every added event
+// is sent or written to disk
+type drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// saveCheckpoint drain the consumer and save the progress, the file is replaced atomically. Flush of
+// consumers without Drain is synchronous.
+func (ta *SDAnalytics) saveCheckpoint(ctx context.Context, path, name string, line int) error {
+	var err error
+	if d, ok := ta.consumer.(drainer); ok {
+		err = d.Drain(ctx)
+	} else {
+		err = ta.consumer.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(importCheckpoint{Name: name, Line: line})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0664); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rateLimiter spread events evenly, nil means no limit
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	if l.next.After(now) {
+		timer := time.NewTimer(l.next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	} else {
+		l.next = now
+	}
+	l.next = l.next.Add(l.interval)
+	return nil
+}
@@ -0,0 +1,308 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memoryConsumer struct {
+	data []Data
+}
+
+func (c *memoryConsumer) Add(d Data) error {
+	c.data = append(c.data, d)
+	return nil
+}
+func (c *memoryConsumer) Flush() error      { return nil }
+func (c *memoryConsumer) Close() error      { return nil }
+func (c *memoryConsumer) IsStringent() bool { return false }
+
+func TestImportNDJSON(t *testing.T) {
+	source := `{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"login","#uuid":"u1","properties":{"level":3}}
+{"#type":"track","#time":"2023-05-01 08:00:01.000","#distinct_id":"d1","#event_name":"bad name","properties":{}}
+{"#type":"user_add","#time":"2023-05-01 08:00:02.000","#account_id":"a1","properties":{"coin":10}}
+{"#type":"track","#distinct_id":"d1","#event_name":"no_time","properties":{}}
+`
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	var invalid []int
+	config := ImportConfig{
+		Checkpoint:      checkpoint,
+		CheckpointEvery: 1,
+		SkipInvalid:     true,
+		OnInvalid:       func(line int, err error) { invalid = append(invalid, line) },
+	}
+	result, err := ta.Import(context.Background(), strings.NewReader(source), "dump.log", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 2 || result.Invalid != 2 || len(invalid) != 2 || invalid[0] != 2 || invalid[1] != 4 {
+		t.Fatalf("unexpected result: %+v, invalid lines %v", result, invalid)
+	}
+	d := consumer.data[0]
+	if d.Time != "2023-05-01 08:00:00.000" || d.UUID != "u1" || d.Properties["level"] != json.Number("3") {
+		t.Fatalf("source fields should be kept: %+v", d)
+	}
+	if consumer.data[1].UUID == "" || consumer.data[1].Time != "2023-05-01 08:00:02.000" {
+		t.Fatalf("unexpected event: %+v", consumer.data[1])
+	}
+
+	// 从checkpoint恢复时跳过已导入的行，生成的#uuid保持不变
+	more := source + `{"#type":"track","#time":"2023-05-01 08:00:03.000","#distinct_id":"d2","#event_name":"logout","properties":{}}` + "\n"
+	result, err = ta.Import(context.Background(), strings.NewReader(more), "dump.log", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resumed != 4 || result.Imported != 1 || consumer.data[2].EventName != "logout" {
+		t.Fatalf("unexpected resumed result: %+v", result)
+	}
+	if _, err = ta.Import(context.Background(), strings.NewReader(more), "other.log", config); err == nil {
+		t.Fatal("checkpoint of another source should be rejected")
+	}
+
+	// 不跳过无效日志时停止导入并报告行号
+	_, err = ta.Import(context.Background(), strings.NewReader(source), "dump.log", ImportConfig{})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 数数的日志#time是写入方的本地时间，根据#zone_offset转换为UTC
+	zoned := `{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"login","properties":{"#zone_offset":8}}
+{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"login","properties":{"#zone_offset":-5.5}}
+`
+	consumer.data = nil
+	if _, err = ta.Import(context.Background(), strings.NewReader(zoned), "zoned.log", ImportConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if consumer.data[0].Time != "2023-05-01 00:00:00.000" || consumer.data[1].Time != "2023-05-01 13:30:00.000" {
+		t.Fatalf("#time should be converted by #zone_offset: %s, %s", consumer.data[0].Time, consumer.data[1].Time)
+	}
+	if consumer.data[0].Properties["#zone_offset"] != json.Number("8") {
+		t.Fatalf("#zone_offset should be kept: %+v", consumer.data[0].Properties)
+	}
+}
+
+func TestImportFileSameName(t *testing.T) {
+	// 不同目录下的同名文件是不同的数据源
+	source := `{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"login","properties":{}}` + "\n"
+	dir := t.TempDir()
+	var paths []string
+	for _, month := range []string{"2023-05", "2023-06"} {
+		path := filepath.Join(dir, month, "events.ndjson")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+	for _, path := range paths {
+		if _, err := ta.ImportFile(context.Background(), path, ImportConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(consumer.data) != 2 || consumer.data[0].UUID == consumer.data[1].UUID {
+		t.Fatalf("files with the same base name should get different #uuid: %+v", consumer.data)
+	}
+
+	// checkpoint属于第一个文件，不能被第二个文件使用
+	checkpoint := filepath.Join(dir, "events.ndjson.checkpoint")
+	if _, err := ta.ImportFile(context.Background(), paths[0], ImportConfig{Checkpoint: checkpoint}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ta.ImportFile(context.Background(), paths[1], ImportConfig{Checkpoint: checkpoint}); err == nil {
+		t.Fatal("checkpoint of another file with the same base name should be rejected")
+	}
+}
+
+func TestImportCheckpointDrain(t *testing.T) {
+	// 服务端交替接收和拒绝批次，被拒绝的批次写入缓存文件
+	var mu sync.Mutex
+	reject := map[string]bool{} // 批次ID -> 是否拒绝
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec := CompressCodec(r.Header.Get("Content-Encoding"))
+		if codec == "" {
+			codec = CodecNone
+		}
+		reader, err := NewDecompressReader(r.Body, codec)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(reader)
+		mu.Lock()
+		defer mu.Unlock()
+		id := r.Header.Get(HeaderBatchId)
+		rejected, ok := reject[id]
+		if !ok {
+			rejected = len(reject)%2 == 1
+			reject[id] = rejected
+			if !rejected {
+				sent += bytes.Count(body, []byte("\n"))
+			}
+		}
+		if rejected {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var source strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&source, `{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"login","properties":{"i":%d}}`+"\n", i)
+	}
+	dir := t.TempDir()
+	config := SDBatchConfig{TempDir: filepath.Join(dir, "spool"), ServerUrl: server.URL, AppId: "app", BatchSize: 3, Interval: 60, Protocol: ProtocolV2}
+	c, err := NewBatchConsumer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkpoint := filepath.Join(dir, "checkpoint")
+	result, err := New(c).Import(context.Background(), strings.NewReader(source.String()), "dump.log", ImportConfig{Checkpoint: checkpoint, CheckpointEvery: 5})
+	if err != nil || result.Imported != 20 {
+		t.Fatalf("import failed: %v, %+v", err, result)
+	}
+	if line, _ := loadCheckpoint(checkpoint, "dump.log"); line != 20 {
+		t.Fatalf("unexpected checkpoint: %d", line)
+	}
+
+	// 进程在保存进度后立即退出，已保存进度的日志都已发送或写入缓存文件
+	files, err := ListSpool(config.TempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spooled := 0
+	for _, f := range files {
+		if err = ReadSpool(f.Path, func(n int, line []byte) error { spooled++; return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	sentBefore := sent
+	mu.Unlock()
+	if spooled == 0 || sentBefore+spooled != 20 {
+		t.Fatalf("events lost after checkpoint: %d sent, %d spooled", sentBefore, spooled)
+	}
+
+	// 没有TempDir时发送失败的日志被丢弃，不保存进度
+	c2, err := NewBatchConsumer(SDBatchConfig{ServerUrl: server.URL, AppId: "app", BatchSize: 3, Interval: 60, Protocol: ProtocolV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	lost := filepath.Join(dir, "lost")
+	if _, err = New(c2).Import(context.Background(), strings.NewReader(source.String()), "dump.log", ImportConfig{Checkpoint: lost, CheckpointEvery: 5}); err == nil {
+		t.Fatal("import should fail when events are dropped")
+	}
+	if _, err = os.Stat(lost); err == nil {
+		t.Fatal("checkpoint should not be saved when events are dropped")
+	}
+}
+
+// drainConsumer memoryConsumer counting calls of Drain
+type drainConsumer struct {
+	memoryConsumer
+	drains int
+}
+
+func (c *drainConsumer) Drain(ctx context.Context) error {
+	c.drains++
+	return nil
+}
+
+func TestImportWithoutCheckpoint(t *testing.T) {
+	var source strings.Builder
+	for i := 0; i < 5; i++ {
+		source.WriteString(`{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"login","properties":{}}` + "\n")
+	}
+	consumer := &drainConsumer{}
+	if _, err := New(consumer).Import(context.Background(), strings.NewReader(source.String()), "dump.log", ImportConfig{CheckpointEvery: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// 没有checkpoint时只在结束时drain一次
+	if len(consumer.data) != 5 || consumer.drains != 1 {
+		t.Fatalf("expect 5 events and 1 drain, got %d events and %d drains", len(consumer.data), consumer.drains)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	source := "uid,ts,event,gold,vip,note\n" +
+		"1001,2023-05-01 16:00:00,buy,12,true,\"a,b\"\n" +
+		"1002,1682928000000,buy,x,false,\n"
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+	mapping := &ImportMapping{
+		Columns:    map[string]string{"uid": "#account_id", "ts": "#time", "event": "#event_name", "note": "-"},
+		Types:      map[string]string{"gold": "number", "vip": "bool"},
+		TimeFormat: "2006-01-02 15:04:05",
+		TimeZone:   "Asia/Shanghai",
+	}
+	var errs []error
+	result, err := ta.Import(context.Background(), strings.NewReader(source), "legacy.csv", ImportConfig{
+		Format:      ImportCSV,
+		Mapping:     mapping,
+		SkipInvalid: true,
+		OnInvalid:   func(line int, err error) { errs = append(errs, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 1 || result.Invalid != 1 {
+		t.Fatalf("unexpected result: %+v, %v", result, errs)
+	}
+	d := consumer.data[0]
+	// 源数据的时区转换为UTC
+	if d.AccountId != "1001" || d.EventName != "buy" || d.Time != "2023-05-01 08:00:00.000" {
+		t.Fatalf("unexpected event: %+v", d)
+	}
+	if d.Properties["gold"] != int64(12) || d.Properties["vip"] != true || d.Properties["note"] != nil {
+		t.Fatalf("unexpected properties: %+v", d.Properties)
+	}
+}
+
+func TestImportLargeNumbers(t *testing.T) {
+	// 超过2^53的数字ID和属性不能因为float64丢失精度
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+	mapped := `{"uid":12345678901234567,"ts":"2023-05-01 08:00:00.000","event":"buy","order":12345678901234567,"extra":"{\"id\":12345678901234567}"}` + "\n"
+	mapping := &ImportMapping{
+		Columns: map[string]string{"uid": "#account_id", "ts": "#time", "event": "#event_name"},
+		Types:   map[string]string{"order": "number", "extra": "json"},
+	}
+	if _, err := ta.Import(context.Background(), strings.NewReader(mapped), "mapped.log", ImportConfig{Mapping: mapping}); err != nil {
+		t.Fatal(err)
+	}
+	raw := `{"#type":"track","#time":"2023-05-01 08:00:00.000","#distinct_id":"d1","#event_name":"buy","properties":{"order":12345678901234567}}` + "\n"
+	if _, err := ta.Import(context.Background(), strings.NewReader(raw), "raw.log", ImportConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := consumer.data[0]; d.AccountId != "12345678901234567" {
+		t.Fatalf("unexpected account id: %s", d.AccountId)
+	}
+	for i, want := range []string{
+		`{"extra":{"id":12345678901234567},"order":12345678901234567}`,
+		`{"order":12345678901234567}`,
+	} {
+		p := consumer.data[i].Properties
+		delete(p, "#lib")
+		delete(p, "#lib_version")
+		if bs, _ := json.Marshal(p); string(bs) != want {
+			t.Fatalf("unexpected properties: %s", bs)
+		}
+	}
+}
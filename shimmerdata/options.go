@@ -0,0 +1,82 @@
+package shimmerdata
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Option configures SDAnalytics and consumers, see New, NewBatchConsumer, NewLogConsumerWithConfig etc.
+// instances without options use the package level defaults.
+type Option func(*options)
+
+type options struct {
+	logger    *slog.Logger
+	logLevel  SDLogLevel
+	logOutput SDLogger
+	level     *logLevelVar // level of the default handler, nil if logger is supplied
+
+	reservedKeyPolicy ReservedKeyPolicy   // SDAnalytics only
+	serverPresets     *ServerPresetConfig // SDAnalytics only
+	identityStore     IdentityStore       // SDAnalytics only
+}
+
+// WithLogger send internal logs of the instance to logger instead of the package level SDLogger.
+// the level of logger decides which logs are written, SetLogLevel and WithLogLevel do not apply.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithLogHandler same as WithLogger(slog.New(handler))
+func WithLogHandler(handler slog.Handler) Option {
+	return func(o *options) {
+		if handler != nil {
+			o.logger = slog.New(handler)
+		}
+	}
+}
+
+// WithLogLevel log output level of the instance, overrides SetLogLevel
+func WithLogLevel(level SDLogLevel) Option {
+	return func(o *options) {
+		if !level.valid() {
+			fmt.Println(SdkLogPrefix + "log type error")
+			return
+		}
+		o.logLevel = level
+	}
+}
+
+// WithCustomLogger log output of the instance, overrides SetCustomLogger
+func WithCustomLogger(logger SDLogger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logOutput = logger
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.level = &logLevelVar{}
+		o.level.level.Store(int32(o.logLevel))
+		o.logger = slog.New(&defaultHandler{level: o.level, output: o.logOutput})
+	}
+	return o
+}
+
+// instanceLog internal logger of the instance, attrs are added to every record
+func (o options) instanceLog(attrs ...any) instanceLog {
+	log := o.logger
+	if len(attrs) > 0 {
+		log = log.With(attrs...)
+	}
+	return instanceLog{log: log, level: o.level}
+}
@@ -0,0 +1,184 @@
+package shimmerdata
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PresetKind type of the value of a preset key
+type PresetKind int32
+
+const (
+	PresetString PresetKind = 0 // string
+	PresetNumber PresetKind = 1 // any number type
+	PresetBool   PresetKind = 2 // bool
+	PresetTime   PresetKind = 3 // time.Time, or string in DATE_FORMAT
+)
+
+// PresetRule who may set a preset key in properties
+type PresetRule int32
+
+const (
+	PresetUser  PresetRule = 0 // users may set the key
+	PresetSDK   PresetRule = 1 // set by the SDK only
+	PresetField PresetRule = 2 // a field of Data such as #type, never allowed in properties
+)
+
+// PresetKey a reserved "#" key known by the SDK
+type PresetKey struct {
+	Name string
+	Kind PresetKind
+	Rule PresetRule
+}
+
+// ReservedKeyPolicy what to do with "#" keys in properties that are not registered by RegisterPresetKey
+type ReservedKeyPolicy int32
+
+const (
+	ReservedKeyWarn   ReservedKeyPolicy = 0 // keep the key and log a warning, the default
+	ReservedKeyDrop   ReservedKeyPolicy = 1 // remove the key and log a warning
+	ReservedKeyReject ReservedKeyPolicy = 2 // reject the event
+)
+
+var (
+	presetMutex sync.RWMutex
+	presetKeys  = map[string]PresetKey{}
+)
+
+func init() {
+	for _, key := range []PresetKey{
+		{Name: "#time", Kind: PresetTime, Rule: PresetUser},
+		{Name: "#ip", Kind: PresetString, Rule: PresetUser},
+		{Name: "#uuid", Kind: PresetString, Rule: PresetUser},
+		{Name: "#app_id", Kind: PresetString, Rule: PresetUser},
+		{Name: "#first_check_id", Kind: PresetString, Rule: PresetUser},
+		{Name: "#zone_offset", Kind: PresetNumber, Rule: PresetUser},
+		{Name: "#session_id", Kind: PresetString, Rule: PresetUser}, // added by SessionManager
+		// added by WithServerPresets
+		{Name: "#hostname", Kind: PresetString, Rule: PresetUser},
+		{Name: "#pid", Kind: PresetNumber, Rule: PresetUser},
+		{Name: "#container", Kind: PresetString, Rule: PresetUser},
+		{Name: "#go_version", Kind: PresetString, Rule: PresetUser},
+		{Name: "#region", Kind: PresetString, Rule: PresetUser},
+		{Name: "#build_version", Kind: PresetString, Rule: PresetUser},
+		{Name: "#git_sha", Kind: PresetString, Rule: PresetUser},
+		{Name: "#process_session_id", Kind: PresetString, Rule: PresetUser},
+		{Name: "#process_start_time", Kind: PresetTime, Rule: PresetUser},
+		{Name: "#lib", Kind: PresetString, Rule: PresetSDK},
+		{Name: "#lib_version", Kind: PresetString, Rule: PresetSDK},
+		{Name: "#account_id", Kind: PresetString, Rule: PresetField},
+		{Name: "#distinct_id", Kind: PresetString, Rule: PresetField},
+		{Name: "#type", Kind: PresetString, Rule: PresetField},
+		{Name: "#event_name", Kind: PresetString, Rule: PresetField},
+		{Name: "#event_id", Kind: PresetString, Rule: PresetField},
+	} {
+		presetKeys[key.Name] = key
+	}
+}
+
+// RegisterPresetKey add or replace a preset key, for example a "#" property agreed with the warehouse.
+// the name must start with "#" and match KEY_PATTERN.
+func RegisterPresetKey(key PresetKey) error {
+	if !isBuildInAttribute(key.Name) || !checkPattern([]byte(key.Name)) {
+		return fmt.Errorf("invalid preset key: %s, should start with # and match %s", key.Name, KEY_PATTERN)
+	}
+	if key.Kind < PresetString || key.Kind > PresetTime {
+		return fmt.Errorf("invalid kind of preset key %s: %d", key.Name, key.Kind)
+	}
+	if key.Rule < PresetUser || key.Rule > PresetField {
+		return fmt.Errorf("invalid rule of preset key %s: %d", key.Name, key.Rule)
+	}
+	presetMutex.Lock()
+	presetKeys[key.Name] = key
+	presetMutex.Unlock()
+	return nil
+}
+
+// PresetKeys all registered preset keys
+func PresetKeys() []PresetKey {
+	presetMutex.RLock()
+	defer presetMutex.RUnlock()
+	keys := make([]PresetKey, 0, len(presetKeys))
+	for _, key := range presetKeys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func lookupPresetKey(name string) (PresetKey, bool) {
+	presetMutex.RLock()
+	key, ok := presetKeys[name]
+	presetMutex.RUnlock()
+	return key, ok
+}
+
+// WithReservedKeyPolicy what SDAnalytics does with unknown "#" keys in properties, default ReservedKeyWarn
+func WithReservedKeyPolicy(policy ReservedKeyPolicy) Option {
+	return func(o *options) {
+		o.reservedKeyPolicy = policy
+	}
+}
+
+func (k PresetKey) check(v interface{}) error {
+	switch k.Kind {
+	case PresetString:
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s should be a string, got %T", k.Name, v)
+		}
+	case PresetNumber:
+		if isNotNumber(v) {
+			return fmt.Errorf("%s should be a number, got %T", k.Name, v)
+		}
+	case PresetBool:
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s should be a bool, got %T", k.Name, v)
+		}
+	case PresetTime:
+		switch t := v.(type) {
+		case time.Time:
+		case string:
+			if _, err := time.Parse(DATE_FORMAT, t); err != nil {
+				return fmt.Errorf("%s format should be %s", k.Name, DATE_FORMAT)
+			}
+		default:
+			return fmt.Errorf("%s should be time.Time or string, got %T", k.Name, v)
+		}
+	}
+	return nil
+}
+
+// checkPresetKeys check "#" keys of p, which must be a copy owned by the SDK. registered keys must have the
+// right type and be settable by users, unknown keys are handled by the ReservedKeyPolicy.
+// sdkKeys allows keys set by the SDK, for events imported from logs written by the SDK.
+func (ta *SDAnalytics) checkPresetKeys(p map[string]interface{}, sdkKeys bool) error {
+	for k, v := range p {
+		if !isBuildInAttribute(k) {
+			continue
+		}
+		key, ok := lookupPresetKey(k)
+		if !ok {
+			switch ta.reservedKeyPolicy {
+			case ReservedKeyReject:
+				ta.log.Info("unknown reserved property key", "key", k)
+				return errors.New("unknown reserved property key: " + k)
+			case ReservedKeyDrop:
+				ta.log.Warn("drop unknown reserved property key", "key", k)
+				delete(p, k)
+			default:
+				ta.log.Warn("unknown reserved property key", "key", k)
+			}
+			continue
+		}
+		if key.Rule == PresetField || key.Rule == PresetSDK && !sdkKeys {
+			ta.log.Info("reserved property key can not be set", "key", k)
+			return errors.New("reserved property key can not be set: " + k)
+		}
+		if err := key.check(v); err != nil {
+			ta.log.Info("invalid reserved property", "key", k, "error", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package shimmerdata
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	shimmerdata_go "github.com/ShimmerGames-Co-Ltd/shimmerdata-go"
+)
+
+func TestPresetKeys(t *testing.T) {
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+
+	// 预置属性由SDK设置，不能被用户覆盖
+	for _, key := range []string{"#lib", "#type", "#distinct_id"} {
+		if err := ta.Track("", "distinct", "login", map[string]interface{}{key: "x"}); err == nil {
+			t.Fatalf("%s should be rejected", key)
+		}
+	}
+	ta.SetSuperProperties(map[string]interface{}{"#lib_version": "0"})
+	if err := ta.Track("", "distinct", "login", nil); err == nil {
+		t.Fatal("#lib_version in super properties should be rejected")
+	}
+	ta.ClearSuperProperties()
+
+	// 类型不匹配
+	if err := ta.Track("", "distinct", "login", map[string]interface{}{"#ip": 1}); err == nil {
+		t.Fatal("#ip should be a string")
+	}
+	if err := ta.Track("", "distinct", "login", map[string]interface{}{"#zone_offset": "8"}); err == nil {
+		t.Fatal("#zone_offset should be a number")
+	}
+	if err := ta.UserSet("", "distinct", map[string]interface{}{"#time": "yesterday"}); err == nil {
+		t.Fatal("#time should be in DATE_FORMAT")
+	}
+
+	// 未知的#属性默认保留
+	err := ta.Track("", "distinct", "login", map[string]interface{}{"#ip": "127.0.0.1", "#zone_offset": 8, "#channel": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := consumer.data[len(consumer.data)-1]
+	if d.Ip != "127.0.0.1" || d.Properties["#zone_offset"] != 8 || d.Properties["#channel"] != "web" || d.Properties["#lib"] != shimmerdata_go.LibName {
+		t.Fatalf("unexpected data: %+v", d)
+	}
+	// UserAdd只对注册的#属性豁免数字检查
+	if err := ta.UserAdd("", "distinct", map[string]interface{}{"#channel": "web"}); err == nil {
+		t.Fatal("unknown # key of UserAdd should be a number")
+	}
+
+	drop := New(consumer, WithReservedKeyPolicy(ReservedKeyDrop))
+	if err := drop.Track("", "distinct", "login", map[string]interface{}{"#channel": "web", "level": 1}); err != nil {
+		t.Fatal(err)
+	}
+	d = consumer.data[len(consumer.data)-1]
+	if _, ok := d.Properties["#channel"]; ok || d.Properties["level"] != 1 {
+		t.Fatalf("#channel should be dropped: %+v", d.Properties)
+	}
+
+	reject := New(consumer, WithReservedKeyPolicy(ReservedKeyReject))
+	if err := reject.UserSet("", "distinct", map[string]interface{}{"#channel": "web"}); err == nil {
+		t.Fatal("#channel should be rejected")
+	}
+
+	// 注册后可以设置
+	if err := RegisterPresetKey(PresetKey{Name: "#channel", Kind: PresetString}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		presetMutex.Lock()
+		delete(presetKeys, "#channel")
+		presetMutex.Unlock()
+	}()
+	if err := reject.UserSet("", "distinct", map[string]interface{}{"#channel": "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterPresetKey(PresetKey{Name: "channel"}); err == nil {
+		t.Fatal("preset key should start with #")
+	}
+}
+
+func TestServerPresets(t *testing.T) {
+	t.Setenv(RegionEnv, "eu")
+	t.Setenv("CONTAINER_NAME", "")
+	t.Setenv("POD_NAME", "game-0")
+	consumer := &memoryConsumer{}
+	ta := New(consumer, WithServerPresets(ServerPresetConfig{Hostname: true, PID: true, Container: true, GoVersion: true, RegionFromEnv: true, Build: true, Session: true}))
+	if err := ta.Track("", "distinct", "login", map[string]interface{}{"#pid": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.UserSet("", "distinct", map[string]interface{}{"level": 1}); err != nil {
+		t.Fatal(err)
+	}
+	p := consumer.data[0].Properties
+	// 单个事件的属性覆盖预置属性
+	if p["#pid"] != 1 || p["#region"] != "eu" || p["#container"] != "game-0" || p["#go_version"] != runtime.Version() {
+		t.Fatalf("unexpected properties: %v", p)
+	}
+	if host, _ := os.Hostname(); p["#hostname"] != host {
+		t.Fatalf("unexpected #hostname: %v", p["#hostname"])
+	}
+	if p["#process_session_id"] != processSessionId || p["#process_start_time"] == nil {
+		t.Fatalf("unexpected session: %v", p)
+	}
+	if _, ok := consumer.data[1].Properties["#hostname"]; ok {
+		t.Fatal("server presets should not be added to user properties")
+	}
+
+	// 默认不添加
+	if err := New(consumer).Track("", "distinct", "login", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := consumer.data[2].Properties["#hostname"]; ok {
+		t.Fatal("server presets are opt-in")
+	}
+	// 环境变量只在RegionFromEnv时读取，Region优先
+	if err := New(consumer, WithServerPresets(ServerPresetConfig{Hostname: true})).Track("", "distinct", "login", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := consumer.data[3].Properties["#region"]; ok {
+		t.Fatal("#region should not be read from the environment without RegionFromEnv")
+	}
+	if err := New(consumer, WithServerPresets(ServerPresetConfig{Region: "us", RegionFromEnv: true})).Track("", "distinct", "login", nil); err != nil {
+		t.Fatal(err)
+	}
+	if consumer.data[4].Properties["#region"] != "us" {
+		t.Fatalf("Region should override the environment: %v", consumer.data[4].Properties)
+	}
+}
@@ -3,7 +3,9 @@ package shimmerdata
 import (
 	"fmt"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -14,7 +16,6 @@ type printerConf struct {
 	maxSize    int    //文件大小（默认100MB）
 	maxAge     int    //文件保留时长（单位天，默认是一直保留）
 	maxBackups int    //最大文件个数（0=默认不限制）
-	compress   bool   //是否压缩日志（gzip）
 	filename   string //日志文件名
 }
 
@@ -33,7 +34,7 @@ func newPrinter(conf *printerConf) *printer {
 			MaxAge:     conf.maxAge,
 			MaxBackups: conf.maxBackups,
 			LocalTime:  false,
-			Compress:   conf.compress,
+			Compress:   false, //lumberjack只支持gzip且为异步压缩，改为切割后由compressBackups同步压缩
 		},
 		conf: conf,
 	}
@@ -45,11 +46,35 @@ func (p *printer) Print(data []byte) error {
 	return err
 }
 
-// ForceRotate 强制切分文件。注意：如果切分后立即关闭printer会导致日志文件不能被压缩，因为lumberjack的压缩是异步的。
+// ForceRotate 强制切分文件。切分出的文件需要调用compressBackups压缩
 func (p *printer) ForceRotate() error {
 	return p.Rotate()
 }
 
+// compressBackups 使用指定的算法同步压缩所有已切分但未压缩的文件
+func (p *printer) compressBackups(codec SDCompression) error {
+	if codec.Codec == CodecNone {
+		return nil
+	}
+	files, err := os.ReadDir(p.conf.folder)
+	if err != nil {
+		return err
+	}
+	//lumberjack切分出的文件名为 <app>-logback-<时间>.log
+	prefix := strings.TrimSuffix(filepath.Base(p.conf.filename), ".log") + "-"
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, prefix) || filepath.Ext(name) != ".log" {
+			continue
+		}
+		_, err = transcodeFile(filepath.Join(p.conf.folder, name), codec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // LogLine 查看日志条数
 func (p *printer) LogLine() (int64, error) {
 	cmd := exec.Command("wc", "-l", p.Logger.Filename)
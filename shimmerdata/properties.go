@@ -0,0 +1,121 @@
+package shimmerdata
+
+import (
+	"fmt"
+	"time"
+)
+
+// Properties builder of event and user properties. It is a map[string]interface{}, so it is accepted
+// by Track, UserSet and all other methods taking properties. Only the builder methods restrict the value
+// kinds at compile time, values assigned by index are checked when the event is added like any other map.
+// Values are stored in the format written to the log, keys are checked against KEY_PATTERN when they are set:
+//
+//	props := shimmerdata.NewProperties().Int("level", 5).String("class", "mage").Time("login_time", t)
+//
+// A key that does not match KEY_PATTERN is kept with an error as value, Err returns it and the event
+// is rejected when it is added. Events built from Properties are checked and normalized the same way as
+// maps, the builder does not save allocations.
+type Properties map[string]interface{}
+
+// propertyError value stored for an invalid key
+type propertyError struct {
+	err error
+}
+
+// NewProperties create an empty Properties
+func NewProperties() Properties {
+	return make(Properties)
+}
+
+func (p Properties) set(key string, v interface{}) Properties {
+	if p == nil {
+		p = make(Properties)
+	}
+	if !checkPattern([]byte(key)) {
+		v = propertyError{err: fmt.Errorf("invalid property key: %s", key)}
+	}
+	p[key] = v
+	return p
+}
+
+// Int set an integer
+func (p Properties) Int(key string, v int) Properties {
+	return p.set(key, v)
+}
+
+// Int64 set a 64-bit integer
+func (p Properties) Int64(key string, v int64) Properties {
+	return p.set(key, v)
+}
+
+// Float set a number
+func (p Properties) Float(key string, v float64) Properties {
+	return p.set(key, v)
+}
+
+// Bool set a boolean
+func (p Properties) Bool(key string, v bool) Properties {
+	return p.set(key, v)
+}
+
+// String set a string
+func (p Properties) String(key string, v string) Properties {
+	return p.set(key, v)
+}
+
+// Strings set a list of strings
+func (p Properties) Strings(key string, v []string) Properties {
+	return p.set(key, v)
+}
+
+// Time set a time in DATE_FORMAT. #time is kept as time.Time and converted to UTC when the event is added.
+func (p Properties) Time(key string, v time.Time) Properties {
+	if key == "#time" {
+		return p.set(key, v)
+	}
+	return p.set(key, v.Format(DATE_FORMAT))
+}
+
+// Object set a nested object
+func (p Properties) Object(key string, v Properties) Properties {
+	return p.set(key, map[string]interface{}(v))
+}
+
+// Objects set a list of nested objects
+func (p Properties) Objects(key string, v []Properties) Properties {
+	objects := make([]map[string]interface{}, len(v))
+	for i := range v {
+		objects[i] = v[i]
+	}
+	return p.set(key, objects)
+}
+
+// Err the first invalid key of p and nested objects, nil if all keys are valid. adding the event reports
+// the same error, Err is not needed before Track.
+func (p Properties) Err() error {
+	for _, v := range p {
+		if err := propertyErr(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propertyErr the error of an invalid key stored in v and nested objects
+func propertyErr(v interface{}) error {
+	switch value := v.(type) {
+	case propertyError:
+		return value.err
+	case Properties:
+		return value.Err()
+	case map[string]interface{}:
+		return Properties(value).Err()
+	case []map[string]interface{}:
+		for _, o := range value {
+			if err := Properties(o).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package shimmerdata
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProperties(t *testing.T) {
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+	login := time.Date(2024, 1, 2, 11, 4, 5, 0, time.FixedZone("UTC+8", 8*3600))
+	props := NewProperties().
+		Int("level", 5).
+		String("class", "mage").
+		Time("login_time", login).
+		Time("#time", login).
+		Strings("tags", []string{"a", "b"}).
+		Object("guild", NewProperties().Int64("id", 1<<40).Bool("leader", true)).
+		Objects("items", []Properties{NewProperties().Float("price", 1.5)})
+	if err := props.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// Properties可以直接传给接收map的方法
+	if err := ta.Track("", "distinct", "login", props); err != nil {
+		t.Fatal(err)
+	}
+	d := consumer.data[0]
+	if d.Time != "2024-01-02 03:04:05.000" {
+		t.Fatalf("#time should be converted to UTC: %s", d.Time)
+	}
+	bs, _ := json.Marshal(d.Properties)
+	for _, expect := range []string{`"level":5`, `"login_time":"2024-01-02 11:04:05.000"`, `"guild":{"id":1099511627776,"leader":true}`, `"items":[{"price":1.5}]`} {
+		if !strings.Contains(string(bs), expect) {
+			t.Fatalf("expect %s in %s", expect, bs)
+		}
+	}
+	if _, ok := props["#time"]; !ok {
+		t.Fatal("properties of the caller should not be modified")
+	}
+
+	// 非法的key在设置时记录，写入时拒绝
+	var nilProps Properties
+	invalid := nilProps.Int("bad key", 1)
+	if invalid.Err() == nil {
+		t.Fatal("invalid key should be reported")
+	}
+	if err := ta.UserSet("", "distinct", invalid); err == nil {
+		t.Fatal("event with invalid key should be rejected")
+	}
+	// 嵌套对象中的非法key同样拒绝整条日志
+	for _, nested := range []Properties{
+		NewProperties().Int("level", 1).Object("o", NewProperties().String("1x", "v")),
+		NewProperties().Objects("items", []Properties{NewProperties().Int("id", 1), NewProperties().String("1x", "v")}),
+	} {
+		if nested.Err() == nil {
+			t.Fatalf("invalid nested key should be reported: %v", nested)
+		}
+		err := ta.Track("", "distinct", "login", nested)
+		if err == nil || !strings.Contains(err.Error(), "invalid property key: 1x") {
+			t.Fatalf("event with invalid nested key should be rejected, got %v", err)
+		}
+	}
+	if len(consumer.data) != 1 {
+		t.Fatalf("unexpected events: %+v", consumer.data)
+	}
+}
+
+type level int
+
+type item struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("bad marshaler")
+}
+
+func TestPropertyValues(t *testing.T) {
+	consumer := &memoryConsumer{}
+	ta := New(consumer)
+	login := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := 7
+	err := ta.Track("", "distinct", "login", map[string]interface{}{
+		"int64":   int64(1),
+		"uint":    uint(2),
+		"float32": float32(1.5),
+		"number":  json.Number("12.5"),
+		"level":   level(3),
+		"pointer": &n,
+		"nil":     (*int)(nil),
+		"time":    &login,
+		"map":     map[int]interface{}{1: login},
+		"list":    []interface{}{1, "a", map[string]interface{}{"x": []int{1, 2}}},
+		"struct":  item{Id: 1, Name: "sword"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := json.Marshal(consumer.data[0].Properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{`"float32":1.5`, `"number":12.5`, `"level":3`, `"pointer":7`, `"nil":null`,
+		`"time":"2024-01-02 03:04:05.000"`, `"map":{"1":"2024-01-02 03:04:05.000"}`,
+		`"list":[1,"a",{"x":[1,2]}]`, `"struct":{"id":1,"name":"sword"}`} {
+		if !strings.Contains(string(bs), expect) {
+			t.Fatalf("expect %s in %s", expect, bs)
+		}
+	}
+	if !consumer.data[0].IsComplex {
+		t.Fatal("nested values should be complex")
+	}
+
+	deep := map[string]interface{}{}
+	for i, m := 0, deep; i < MaxPropertyDepth+1; i++ {
+		next := map[string]interface{}{}
+		m["a"] = next
+		m = next
+	}
+	for _, c := range []struct {
+		value interface{}
+		path  string
+	}{
+		{make(chan int), "properties.v: unsupported type chan int"},
+		{func() {}, "properties.v: unsupported type func()"},
+		{math.NaN(), "properties.v: NaN is not supported"},
+		{[]interface{}{1, 2, 3, map[string]interface{}{"id": func() {}}}, "properties.v[3].id: unsupported type func()"},
+		{map[string]interface{}{"items": []item{{}}, "bad": badMarshaler{}}, "properties.v.bad: json: error calling MarshalJSON"},
+		{json.Number("x"), "properties.v: invalid number"},
+		{[]byte("x"), "properties.v: []uint8 is not supported"},
+		{deep, "nested deeper than"},
+	} {
+		err = ta.Track("", "distinct", "login", map[string]interface{}{"v": c.value})
+		if err == nil || !strings.Contains(err.Error(), c.path) {
+			t.Fatalf("expect error %q, got %v", c.path, err)
+		}
+	}
+
+	// UserAdd支持所有数字类型
+	if err = ta.UserAdd("", "distinct", map[string]interface{}{"a": uint8(1), "b": level(2), "c": json.Number("3")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.UserAdd("", "distinct", map[string]interface{}{"a": "1"}); err == nil {
+		t.Fatal("string should be rejected by UserAdd")
+	}
+}
@@ -1,15 +1,17 @@
 package shimmerdata
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // SdkLogPrefix const
 const SdkLogPrefix = "[ShimmerData]"
 
-var logInstance SDLogger
-
 type SDLogLevel int32
 
 const (
@@ -20,76 +22,179 @@ const (
 	SDLogLevelDebug   SDLogLevel = 5
 )
 
-// default is SDLogLevelOff
-var currentLogLevel = SDLogLevelOff
+// package level defaults of instances without WithLogLevel, WithCustomLogger or WithLogger option
+var (
+	currentLogLevel atomic.Int32 // default is SDLogLevelOff
+	logInstance     atomic.Pointer[SDLogger]
+)
+
+func init() {
+	currentLogLevel.Store(int32(SDLogLevelOff))
+}
 
 // SDLogger User-defined log classes must comply with interface
 type SDLogger interface {
 	Print(message string)
 }
 
-// SetLogLevel Set the log output level
+func (level SDLogLevel) valid() bool {
+	return level >= SDLogLevelOff && level <= SDLogLevelDebug
+}
+
+// SetLogLevel Set the default log output level of instances without WithLogLevel option
 func SetLogLevel(level SDLogLevel) {
-	if level < SDLogLevelOff || level > SDLogLevelDebug {
+	if !level.valid() {
 		fmt.Println(SdkLogPrefix + "log type error")
 		return
 	} else {
-		currentLogLevel = level
+		currentLogLevel.Store(int32(level))
 	}
 }
 
-// SetCustomLogger Set a custom log input class, usually you don't need to set it up.
+// SetCustomLogger Set the default log output of instances without WithCustomLogger option, usually you don't need to set it up.
 func SetCustomLogger(logger SDLogger) {
 	if logger != nil {
-		logInstance = logger
+		logInstance.Store(&logger)
+	}
+}
+
+// logLevelVar log level of an instance which can be changed at runtime, 0 means the package level default
+type logLevelVar struct {
+	level atomic.Int32
+}
+
+func (v *logLevelVar) get() SDLogLevel {
+	if v != nil {
+		if level := SDLogLevel(v.level.Load()); level != 0 {
+			return level
+		}
 	}
+	return SDLogLevel(currentLogLevel.Load())
+}
+
+// instanceLog internal logger of an instance
+type instanceLog struct {
+	log   *slog.Logger
+	level *logLevelVar // nil if the logger is supplied by WithLogger or WithLogHandler
 }
 
-func sdLog(level SDLogLevel, format string, v ...interface{}) {
-	if level > currentLogLevel {
+// SetLogLevel change the log output level of the instance at runtime.
+// it has no effect if the logger is supplied by WithLogger or WithLogHandler.
+func (l *instanceLog) SetLogLevel(level SDLogLevel) {
+	if !level.valid() {
+		fmt.Println(SdkLogPrefix + "log type error")
 		return
 	}
+	if l.level != nil {
+		l.level.level.Store(int32(level))
+	}
+}
+
+// defaultHandler slog.Handler keeping the legacy output format.
+// records are filtered by level and written to output, or stdout. zero values fall back to
+// the package level defaults set by SetLogLevel and SetCustomLogger.
+type defaultHandler struct {
+	level  *logLevelVar
+	output SDLogger
+	attrs  []slog.Attr
+	prefix string // key prefix of groups
+}
+
+func (h *defaultHandler) logLevel() SDLogLevel {
+	return h.level.get()
+}
+
+func (h *defaultHandler) logOutput() SDLogger {
+	if h.output != nil {
+		return h.output
+	}
+	if l := logInstance.Load(); l != nil {
+		return *l
+	}
+	return nil
+}
+
+// sdLogLevelOf map slog.Level to SDLogLevel
+func sdLogLevelOf(level slog.Level) SDLogLevel {
+	switch {
+	case level >= slog.LevelError:
+		return SDLogLevelError
+	case level >= slog.LevelWarn:
+		return SDLogLevelWarning
+	case level >= slog.LevelInfo:
+		return SDLogLevelInfo
+	default:
+		return SDLogLevelDebug
+	}
+}
+
+func (h *defaultHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return sdLogLevelOf(level) <= h.logLevel()
+}
 
+func (h *defaultHandler) Handle(_ context.Context, r slog.Record) error {
 	var modeStr string
-	switch level {
+	switch sdLogLevelOf(r.Level) {
 	case SDLogLevelError:
 		modeStr = "[Error] "
-		break
 	case SDLogLevelWarning:
 		modeStr = "[Warning] "
-		break
-	case SDLogLevelInfo:
-		modeStr = "[Info] "
-		break
 	case SDLogLevelDebug:
 		modeStr = "[Debug] "
-		break
 	default:
 		modeStr = "[Info] "
-		break
 	}
 
-	if logInstance != nil {
-		msg := fmt.Sprintf(SdkLogPrefix+modeStr+format+"\n", v...)
-		logInstance.Print(msg)
+	var b strings.Builder
+	b.WriteString(SdkLogPrefix + modeStr + r.Message)
+	for _, a := range h.attrs {
+		writeAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+
+	if output := h.logOutput(); output != nil {
+		output.Print(b.String())
 	} else {
 		logTime := fmt.Sprintf("[%v]", time.Now().Format("2006-01-02 15:04:05.000"))
-		fmt.Printf(logTime+SdkLogPrefix+modeStr+format+"\n", v...)
+		fmt.Print(logTime + b.String())
 	}
+	return nil
 }
 
-func sdLogDebug(format string, v ...interface{}) {
-	sdLog(SDLogLevelDebug, format, v...)
-}
-
-func sdLogInfo(format string, v ...interface{}) {
-	sdLog(SDLogLevelInfo, format, v...)
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := &defaultHandler{level: h.level, output: h.output, prefix: h.prefix}
+	h2.attrs = append(h2.attrs, h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		h2.attrs = append(h2.attrs, a)
+	}
+	return h2
 }
 
-func sdLogError(format string, v ...interface{}) {
-	sdLog(SDLogLevelError, format, v...)
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &defaultHandler{level: h.level, output: h.output, attrs: h.attrs, prefix: h.prefix + name + "."}
 }
 
-func sdLogWarning(format string, v ...interface{}) {
-	sdLog(SDLogLevelWarning, format, v...)
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			writeAttr(b, prefix, ga)
+		}
+		return
+	}
+	fmt.Fprintf(b, " %s%s=%v", prefix, a.Key, a.Value.Any())
 }
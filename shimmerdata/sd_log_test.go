@@ -0,0 +1,102 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Print(message string) {
+	l.messages = append(l.messages, message)
+}
+
+func TestDefaultHandler(t *testing.T) {
+	logger := &testLogger{}
+	level := &logLevelVar{}
+	level.level.Store(int32(SDLogLevelWarning))
+	log := slog.New(&defaultHandler{level: level, output: logger}).With("app", "app-id")
+	log.Info("filtered")
+	log.Warn("send batch failed", "size", 20, slog.Group("file", "name", "a.log"))
+	if len(logger.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(logger.messages))
+	}
+	want := "[ShimmerData][Warning] send batch failed app=app-id size=20 file.name=a.log\n"
+	if logger.messages[0] != want {
+		t.Fatalf("got %q, want %q", logger.messages[0], want)
+	}
+}
+
+func TestPerInstanceLogOptions(t *testing.T) {
+	loggers := []*testLogger{{}, {}}
+	levels := []SDLogLevel{SDLogLevelInfo, SDLogLevelOff}
+	for i := range loggers {
+		i := i
+		t.Run(fmt.Sprintf("app%d", i), func(t *testing.T) {
+			t.Parallel()
+			opts := []Option{WithLogLevel(levels[i]), WithCustomLogger(loggers[i])}
+			c, err := NewWriterConsumer(io.Discard, SDWriterConsumerConfig{}, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := New(c, opts...)
+			err = client.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	t.Cleanup(func() {
+		if len(loggers[0].messages) == 0 {
+			t.Error("info logs of the first instance are missing")
+		}
+		if len(loggers[1].messages) != 0 {
+			t.Errorf("logs of the second instance should be off: %v", loggers[1].messages)
+		}
+	})
+}
+
+func TestWithLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	c, err := NewWriterConsumer(io.Discard, SDWriterConsumerConfig{}, WithLogHandler(handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c, WithLogHandler(handler))
+	err = client.Track("", "", "event_name", nil)
+	if err == nil {
+		t.Fatal("empty ids should fail")
+	}
+	err = client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		err = json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, record["msg"].(string))
+	}
+	want := []string{
+		"Mode: writer consumer",
+		"init SDK success",
+		"invalid parameters: account_id and distinct_id cannot be empty at the same time",
+		"writer consumer close",
+		"SDK close",
+	}
+	if strings.Join(messages, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %v, want %v", messages, want)
+	}
+}
@@ -0,0 +1,96 @@
+package shimmerdata
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// RegionEnv environment variable of #region, read if ServerPresetConfig.RegionFromEnv is set
+const RegionEnv = EnvPrefix + "REGION"
+
+// ServerPresetConfig opt-in preset properties of the server process, added to every event of Track,
+// TrackFirst, TrackUpdate and TrackOverwrite. the values are read once by New, super properties and
+// properties of the event override them.
+type ServerPresetConfig struct {
+	Hostname      bool   // #hostname
+	PID           bool   // #pid
+	Container     bool   // #container: CONTAINER_NAME, POD_NAME, or HOSTNAME inside kubernetes. omitted if not found
+	GoVersion     bool   // #go_version
+	Region        string // #region, omitted if empty
+	RegionFromEnv bool   // read #region from the environment variable SHIMMERDATA_REGION if Region is empty
+	Build         bool   // #build_version and #git_sha from debug.ReadBuildInfo, omitted if not found
+	Session       bool   // #process_session_id and #process_start_time, same for all instances of the process
+}
+
+var (
+	processStartTime = time.Now()
+	processSessionId = generateUUID()
+)
+
+// WithServerPresets add preset properties of the server process to events of SDAnalytics
+func WithServerPresets(config ServerPresetConfig) Option {
+	return func(o *options) {
+		o.serverPresets = &config
+	}
+}
+
+// properties read the values of enabled presets
+func (c ServerPresetConfig) properties() map[string]interface{} {
+	p := make(map[string]interface{})
+	if c.Hostname {
+		if hostname, err := os.Hostname(); err == nil {
+			p["#hostname"] = hostname
+		}
+	}
+	if c.PID {
+		p["#pid"] = os.Getpid()
+	}
+	if c.Container {
+		if container := containerName(); container != "" {
+			p["#container"] = container
+		}
+	}
+	if c.GoVersion {
+		p["#go_version"] = runtime.Version()
+	}
+	region := c.Region
+	if region == "" && c.RegionFromEnv {
+		region = os.Getenv(RegionEnv)
+	}
+	if region != "" {
+		p["#region"] = region
+	}
+	if c.Build {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			// go run and go test report (devel)
+			if info.Main.Version != "" && info.Main.Version != "(devel)" {
+				p["#build_version"] = info.Main.Version
+			}
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" && s.Value != "" {
+					p["#git_sha"] = s.Value
+				}
+			}
+		}
+	}
+	if c.Session {
+		p["#process_session_id"] = processSessionId
+		p["#process_start_time"] = processStartTime.UTC().Format(DATE_FORMAT)
+	}
+	return p
+}
+
+func containerName() string {
+	for _, env := range []string{"CONTAINER_NAME", "POD_NAME"} {
+		if name := os.Getenv(env); name != "" {
+			return name
+		}
+	}
+	// HOSTNAME is the pod name in kubernetes
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return os.Getenv("HOSTNAME")
+	}
+	return ""
+}
@@ -0,0 +1,235 @@
+package shimmerdata
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	SessionStartEvent = "session_start" // sent by SessionManager.Start
+	SessionEndEvent   = "session_end"   // sent when a session ends, with duration and end_reason
+
+	DefaultSessionIdleTimeout = 30 * time.Minute
+)
+
+// end_reason of SessionEndEvent
+const (
+	SessionEndLogout   = "logout"   // SessionManager.End
+	SessionEndRelogin  = "relogin"  // SessionManager.Start of a player with an active session
+	SessionEndIdle     = "idle"     // no event within IdleTimeout
+	SessionEndShutdown = "shutdown" // SessionManager.Close or SDAnalytics.Close
+)
+
+// SessionConfig config of SessionManager
+type SessionConfig struct {
+	IdleTimeout time.Duration // a session without events ends after IdleTimeout, default DefaultSessionIdleTimeout
+}
+
+// SessionManager sessions of players. a player is identified by the account id, or the distinct id if the
+// account id is empty, after the missing id is filled by WithIdentityStore like Track does. a session started
+// by a guest is moved to the account once the guest is bound by BindAccount. #session_id of the active session is added to events sent by Track, TrackFirst,
+// TrackUpdate and TrackOverwrite of the player, unless it is set in properties.
+type SessionManager struct {
+	ta          *SDAnalytics
+	idleTimeout time.Duration
+	sessions    map[string]*session
+	mutex       sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+type session struct {
+	id         string
+	accountId  string
+	distinctId string
+	start      time.Time
+	lastActive time.Time
+}
+
+// NewSessionManager create the SessionManager of ta, which replaces the previous one. active sessions are
+// ended when ta is closed.
+func NewSessionManager(ta *SDAnalytics, config SessionConfig) *SessionManager {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	m := &SessionManager{
+		ta:          ta,
+		idleTimeout: config.IdleTimeout,
+		sessions:    make(map[string]*session),
+		done:        make(chan struct{}),
+	}
+	go m.expire()
+	if old := ta.sessions.Swap(m); old != nil {
+		old.Close()
+	}
+	return m
+}
+
+func sessionKey(accountId, distinctId string) string {
+	if len(accountId) > 0 {
+		return accountId
+	}
+	return distinctId
+}
+
+// lookup the session of the player and its key. a session started by the guest before it was bound is
+// moved to the account. m.mutex must be held
+func (m *SessionManager) lookup(accountId, distinctId string) (string, *session) {
+	if len(accountId) > 0 {
+		if s := m.sessions[accountId]; s != nil {
+			return accountId, s
+		}
+	}
+	if len(distinctId) == 0 {
+		return "", nil
+	}
+	s := m.sessions[distinctId]
+	if s == nil || len(accountId) == 0 {
+		return distinctId, s
+	}
+	if len(s.accountId) > 0 {
+		// the key is the account id of another player
+		return "", nil
+	}
+	delete(m.sessions, distinctId)
+	s.accountId = accountId
+	m.sessions[accountId] = s
+	return accountId, s
+}
+
+// Start start a session after the player logs in and send SessionStartEvent with properties.
+// the active session of the player is ended first.
+func (m *SessionManager) Start(accountId, distinctId string, properties map[string]interface{}) (string, error) {
+	accountId, distinctId = m.ta.resolveIdentity(accountId, distinctId)
+	key := sessionKey(accountId, distinctId)
+	if len(key) == 0 {
+		msg := "invalid parameters: account_id and distinct_id cannot be empty at the same time"
+		m.ta.log.Info(msg)
+		return "", errors.New(msg)
+	}
+	now := time.Now()
+	s := &session{id: generateUUID(), accountId: accountId, distinctId: distinctId, start: now, lastActive: now}
+	m.mutex.Lock()
+	select {
+	case <-m.done:
+		m.mutex.Unlock()
+		msg := "session manager has been closed"
+		m.ta.log.Info(msg)
+		return "", errors.New(msg)
+	default:
+	}
+	oldKey, old := m.lookup(accountId, distinctId)
+	if old != nil {
+		delete(m.sessions, oldKey)
+	}
+	m.sessions[key] = s
+	m.mutex.Unlock()
+
+	if old != nil {
+		if err := m.end(old, SessionEndRelogin, now); err != nil {
+			m.ta.log.Warn("end session failed", "session", old.id, "error", err)
+		}
+	}
+	p := make(map[string]interface{}, len(properties)+1)
+	mergeProperties(p, properties)
+	p["#session_id"] = s.id
+	return s.id, m.ta.Track(accountId, distinctId, SessionStartEvent, p)
+}
+
+// End end the session of the player after logout, nothing is sent if the player has no session
+func (m *SessionManager) End(accountId, distinctId string) error {
+	accountId, distinctId = m.ta.resolveIdentity(accountId, distinctId)
+	m.mutex.Lock()
+	key, s := m.lookup(accountId, distinctId)
+	if s != nil {
+		delete(m.sessions, key)
+	}
+	m.mutex.Unlock()
+	if s == nil {
+		return nil
+	}
+	return m.end(s, SessionEndLogout, time.Now())
+}
+
+// SessionId the active session of the player, empty if not found
+func (m *SessionManager) SessionId(accountId, distinctId string) string {
+	accountId, distinctId = m.ta.resolveIdentity(accountId, distinctId)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, s := m.lookup(accountId, distinctId); s != nil {
+		return s.id
+	}
+	return ""
+}
+
+// Close end all active sessions, Start fails after Close
+func (m *SessionManager) Close() {
+	m.closeOnce.Do(func() {
+		m.mutex.Lock()
+		close(m.done)
+		sessions := m.sessions
+		m.sessions = make(map[string]*session)
+		m.mutex.Unlock()
+		now := time.Now()
+		for _, s := range sessions {
+			if err := m.end(s, SessionEndShutdown, now); err != nil {
+				m.ta.log.Warn("end session failed", "session", s.id, "error", err)
+			}
+		}
+		m.ta.sessions.CompareAndSwap(m, nil)
+	})
+}
+
+// end send SessionEndEvent at the time end
+func (m *SessionManager) end(s *session, reason string, end time.Time) error {
+	duration := end.Sub(s.start).Seconds()
+	return m.ta.Track(s.accountId, s.distinctId, SessionEndEvent, map[string]interface{}{
+		"#session_id": s.id,
+		"#time":       end,
+		"duration":    math.Round(duration*1000) / 1000,
+		"end_reason":  reason,
+	})
+}
+
+// inject add #session_id of the player to p and keep the session active
+func (m *SessionManager) inject(accountId, distinctId string, p map[string]interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, s := m.lookup(accountId, distinctId)
+	if s == nil {
+		return
+	}
+	s.lastActive = time.Now()
+	if _, ok := p["#session_id"]; !ok {
+		p["#session_id"] = s.id
+	}
+}
+
+// expire end sessions without events within idleTimeout, the end time is the last event
+func (m *SessionManager) expire() {
+	ticker := time.NewTicker(m.idleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			var expired []*session
+			m.mutex.Lock()
+			for key, s := range m.sessions {
+				if now.Sub(s.lastActive) >= m.idleTimeout {
+					expired = append(expired, s)
+					delete(m.sessions, key)
+				}
+			}
+			m.mutex.Unlock()
+			for _, s := range expired {
+				if err := m.end(s, SessionEndIdle, s.lastActive); err != nil {
+					m.ta.log.Warn("end session failed", "session", s.id, "error", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package shimmerdata
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncConsumer memoryConsumer used by several goroutines
+type syncConsumer struct {
+	memoryConsumer
+	mutex sync.Mutex
+}
+
+func (c *syncConsumer) Add(d Data) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.memoryConsumer.Add(d)
+}
+
+func (c *syncConsumer) events() []Data {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Data(nil), c.data...)
+}
+
+func TestSessionManager(t *testing.T) {
+	consumer := &syncConsumer{}
+	ta := New(consumer)
+	sessions := NewSessionManager(ta, SessionConfig{IdleTimeout: time.Hour})
+
+	id, err := sessions.Start("account", "", map[string]interface{}{"channel": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Track("account", "", "battle", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Track("", "guest", "battle", nil); err != nil {
+		t.Fatal(err)
+	}
+	events := consumer.events()
+	if events[0].EventName != SessionStartEvent || events[0].Properties["#session_id"] != id || events[0].Properties["channel"] != "web" {
+		t.Fatalf("unexpected session_start: %+v", events[0])
+	}
+	if events[1].Properties["#session_id"] != id {
+		t.Fatalf("#session_id should be injected: %+v", events[1].Properties)
+	}
+	if _, ok := events[2].Properties["#session_id"]; ok {
+		t.Fatal("players without session should not have #session_id")
+	}
+
+	// 重新登录结束旧的会话
+	id2, err := sessions.Start("account", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = consumer.events()
+	if end := events[3]; end.EventName != SessionEndEvent || end.Properties["#session_id"] != id || end.Properties["end_reason"] != SessionEndRelogin {
+		t.Fatalf("unexpected session_end: %+v", end)
+	}
+	if events[4].Properties["#session_id"] != id2 {
+		t.Fatalf("unexpected session_start: %+v", events[4])
+	}
+
+	if err := sessions.End("account", ""); err != nil {
+		t.Fatal(err)
+	}
+	events = consumer.events()
+	if end := events[5]; end.Properties["#session_id"] != id2 || end.Properties["end_reason"] != SessionEndLogout {
+		t.Fatalf("unexpected session_end: %+v", end)
+	}
+	if _, ok := events[5].Properties["duration"].(float64); !ok {
+		t.Fatalf("duration should be a number: %+v", events[5].Properties)
+	}
+	if sessions.SessionId("account", "") != "" {
+		t.Fatal("session should be ended")
+	}
+
+	// 关闭SDK时结束所有会话
+	if _, err := sessions.Start("", "guest", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events = consumer.events()
+	if end := events[len(events)-1]; end.DistinctId != "guest" || end.Properties["end_reason"] != SessionEndShutdown {
+		t.Fatalf("unexpected session_end: %+v", end)
+	}
+	if _, err := sessions.Start("", "guest", nil); err == nil {
+		t.Fatal("Start should fail after Close")
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	consumer := &syncConsumer{}
+	ta := New(consumer)
+	sessions := NewSessionManager(ta, SessionConfig{IdleTimeout: 40 * time.Millisecond})
+	defer sessions.Close()
+
+	start := time.Now()
+	id, err := sessions.Start("account", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Data
+	for events = consumer.events(); len(events) < 2; events = consumer.events() {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("session should be ended by idle timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	end := events[1]
+	if end.Properties["#session_id"] != id || end.Properties["end_reason"] != SessionEndIdle {
+		t.Fatalf("unexpected session_end: %+v", end)
+	}
+	// 结束时间是最后一条日志的时间
+	if d := end.Properties["duration"].(float64); d > 0.03 {
+		t.Fatalf("duration should end at the last event: %v", d)
+	}
+}
+
+func TestSessionIdentity(t *testing.T) {
+	// 会话和Track一样先通过绑定关系补全ID
+	consumer := &syncConsumer{}
+	ta := New(consumer, WithIdentityStore(NewMemoryIdentityStore()))
+	sessions := NewSessionManager(ta, SessionConfig{IdleTimeout: time.Hour})
+	defer sessions.Close()
+
+	if err := ta.BindAccount("account", "guest", nil); err != nil {
+		t.Fatal(err)
+	}
+	id, err := sessions.Start("", "guest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions.SessionId("account", "") != id {
+		t.Fatal("session of the guest should be found by the account")
+	}
+	if err := ta.Track("", "guest", "battle", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Track("account", "", "battle", nil); err != nil {
+		t.Fatal(err)
+	}
+	events := consumer.events()
+	for _, d := range events[2:] {
+		if d.AccountId != "account" || d.Properties["#session_id"] != id {
+			t.Fatalf("#session_id should be injected: %+v", d)
+		}
+	}
+
+	if err := sessions.End("", "guest"); err != nil {
+		t.Fatal(err)
+	}
+	events = consumer.events()
+	if end := events[len(events)-1]; end.EventName != SessionEndEvent || end.Properties["#session_id"] != id {
+		t.Fatalf("unexpected session_end: %+v", end)
+	}
+	if sessions.SessionId("account", "") != "" {
+		t.Fatal("session should be ended")
+	}
+}
+
+func TestSessionBindAfterStart(t *testing.T) {
+	// 访客开始会话后绑定账号，会话转到账号下
+	consumer := &syncConsumer{}
+	ta := New(consumer, WithIdentityStore(NewMemoryIdentityStore()))
+	sessions := NewSessionManager(ta, SessionConfig{IdleTimeout: time.Hour})
+	defer sessions.Close()
+
+	id, err := sessions.Start("", "guest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.BindAccount("account", "guest", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.Track("", "guest", "battle", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.Track("account", "", "battle", nil); err != nil {
+		t.Fatal(err)
+	}
+	events := consumer.events()
+	for _, d := range events[len(events)-2:] {
+		if d.AccountId != "account" || d.Properties["#session_id"] != id {
+			t.Fatalf("#session_id should be injected after binding: %+v", d)
+		}
+	}
+
+	if err = sessions.End("account", ""); err != nil {
+		t.Fatal(err)
+	}
+	events = consumer.events()
+	end := events[len(events)-1]
+	if end.EventName != SessionEndEvent || end.Properties["#session_id"] != id || end.AccountId != "account" || end.DistinctId != "guest" {
+		t.Fatalf("unexpected session_end: %+v", end)
+	}
+	if sessions.SessionId("", "guest") != "" {
+		t.Fatal("session should be ended")
+	}
+
+	// 再次登录只有一个会话，不会结束已经结束的会话
+	id2, err := sessions.Start("account", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(consumer.events()); n != len(events)+1 {
+		t.Fatalf("expect only session_start, got %d more events", n-len(events))
+	}
+	if sessions.SessionId("", "guest") != id2 {
+		t.Fatal("session of the account should be found by the guest")
+	}
+}
@@ -1,9 +1,11 @@
 package shimmerdata
 
 import (
+	"context"
 	"errors"
 	shimmerdata_go "github.com/ShimmerGames-Co-Ltd/shimmerdata-go"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -47,15 +49,30 @@ type SDAnalytics struct {
 	superProperties        map[string]interface{}
 	mutex                  *sync.RWMutex
 	dynamicSuperProperties func() map[string]interface{}
+	reservedKeyPolicy      ReservedKeyPolicy
+	serverPresets          map[string]interface{} // read only
+	identityStore          IdentityStore
+	bindMutex              sync.Mutex // BindAccount checks and binds a guest atomically
+	sessions               atomic.Pointer[SessionManager]
+	instanceLog
 }
 
 // New init SDK
-func New(c SDConsumer) *SDAnalytics {
-	sdLogInfo("init SDK success")
+func New(c SDConsumer, opts ...Option) *SDAnalytics {
+	o := newOptions(opts)
+	o.logger.Info("init SDK success")
+	var serverPresets map[string]interface{}
+	if o.serverPresets != nil {
+		serverPresets = o.serverPresets.properties()
+	}
 	return &SDAnalytics{
-		consumer:        c,
-		superProperties: make(map[string]interface{}),
-		mutex:           new(sync.RWMutex),
+		consumer:          c,
+		superProperties:   make(map[string]interface{}),
+		mutex:             new(sync.RWMutex),
+		reservedKeyPolicy: o.reservedKeyPolicy,
+		serverPresets:     serverPresets,
+		identityStore:     o.identityStore,
+		instanceLog:       o.instanceLog(),
 	}
 }
 
@@ -110,7 +127,7 @@ func (ta *SDAnalytics) Track(accountId, distinctId, eventName string, properties
 func (ta *SDAnalytics) TrackFirst(accountId, distinctId, eventName, firstCheckId string, properties map[string]interface{}) error {
 	if len(firstCheckId) == 0 {
 		msg := "the 'firstCheckId' must be provided"
-		sdLogInfo(msg)
+		ta.log.Info(msg)
 		return errors.New(msg)
 	}
 	p := make(map[string]interface{})
@@ -132,33 +149,43 @@ func (ta *SDAnalytics) TrackOverwrite(accountId, distinctId, eventName, eventId
 func (ta *SDAnalytics) track(accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
-			sdLogError("%+v\ndata: %+v", r, properties)
+			ta.log.Error("panic while adding event", "error", r, "properties", properties)
 		}
 	}()
 
 	if len(eventName) == 0 {
 		msg := "the event name must be provided"
-		sdLogError(msg)
+		ta.log.Error(msg)
 		return errors.New(msg)
 	}
 
 	// eventId not be null unless eventType is equal Track.
 	if len(eventId) == 0 && dataType != Track {
 		msg := "the event id must be provided"
-		sdLogError(msg)
+		ta.log.Error(msg)
 		return errors.New(msg)
 	}
 
-	p := ta.GetSuperProperties()
+	// server presets has the lowest priority
+	p := make(map[string]interface{}, len(ta.serverPresets))
+	mergeProperties(p, ta.serverPresets)
+	mergeProperties(p, ta.GetSuperProperties())
 	dynamicSuperProperties := ta.GetDynamicSuperProperties()
 
 	mergeProperties(p, dynamicSuperProperties)
+	// custom properties
+	mergeProperties(p, properties)
+	if err := ta.checkPresetKeys(p, false); err != nil {
+		return err
+	}
 	// preset properties has the highest priority
 	p["#lib"] = shimmerdata_go.LibName
 	p["#lib_version"] = shimmerdata_go.Version
-	// custom properties
-	mergeProperties(p, properties)
 
+	accountId, distinctId = ta.resolveIdentity(accountId, distinctId)
+	if sessions := ta.sessions.Load(); sessions != nil {
+		sessions.inject(accountId, distinctId, p)
+	}
 	return ta.add(accountId, distinctId, dataType, eventName, eventId, p)
 }
 
@@ -171,7 +198,7 @@ func (ta *SDAnalytics) UserSet(accountId string, distinctId string, properties m
 func (ta *SDAnalytics) UserUnset(accountId string, distinctId string, s []string) error {
 	if len(s) == 0 {
 		msg := "invalid params for UserUnset: keys is nil"
-		sdLogInfo(msg)
+		ta.log.Info(msg)
 		return errors.New(msg)
 	}
 	prop := make(map[string]interface{})
@@ -184,7 +211,7 @@ func (ta *SDAnalytics) UserUnset(accountId string, distinctId string, s []string
 func (ta *SDAnalytics) UserUnsetWithProperties(accountId string, distinctId string, properties map[string]interface{}) error {
 	if len(properties) == 0 {
 		msg := "invalid params for UserUnset: properties is nil"
-		sdLogInfo(msg)
+		ta.log.Info(msg)
 		return errors.New(msg)
 	}
 	return ta.user(accountId, distinctId, UserUnset, properties)
@@ -223,16 +250,20 @@ func (ta *SDAnalytics) UserDeleteWithProperties(accountId string, distinctId str
 func (ta *SDAnalytics) user(accountId, distinctId, dataType string, properties map[string]interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
-			sdLogError("%+v\ndata: %+v", r, properties)
+			ta.log.Error("panic while adding event", "error", r, "properties", properties)
 		}
 	}()
 	if properties == nil && dataType != UserDel {
 		msg := "invalid params for " + dataType + ": properties is nil"
-		sdLogError(msg)
+		ta.log.Error(msg)
 		return errors.New(msg)
 	}
-	p := make(map[string]interface{})
+	p := make(map[string]interface{}, len(properties))
 	mergeProperties(p, properties)
+	if err := ta.checkPresetKeys(p, false); err != nil {
+		return err
+	}
+	accountId, distinctId = ta.resolveIdentity(accountId, distinctId)
 	return ta.add(accountId, distinctId, dataType, "", "", p)
 }
 
@@ -241,36 +272,65 @@ func (ta *SDAnalytics) Flush() error {
 	return ta.consumer.Flush()
 }
 
-// Close and exit sdk
+// Close and exit sdk, active sessions are ended before closing the consumer
 func (ta *SDAnalytics) Close() error {
+	if sessions := ta.sessions.Load(); sessions != nil {
+		sessions.Close()
+	}
 	err := ta.consumer.Close()
-	sdLogInfo("SDK close")
+	ta.log.Info("SDK close")
+	return err
+}
+
+// Shutdown close sdk and wait for the consumer to report data until ctx is done.
+// Consumers without deadline support fall back to Close.
+func (ta *SDAnalytics) Shutdown(ctx context.Context) error {
+	if sessions := ta.sessions.Load(); sessions != nil {
+		sessions.Close()
+	}
+	var err error
+	if c, ok := ta.consumer.(interface{ Shutdown(context.Context) error }); ok {
+		err = c.Shutdown(ctx)
+	} else {
+		err = ta.consumer.Close()
+	}
+	ta.log.Info("SDK close")
 	return err
 }
 
 func (ta *SDAnalytics) add(accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) error {
+	data, err := ta.newData(accountId, distinctId, dataType, eventName, eventId, properties)
+	if err != nil {
+		return err
+	}
+	return ta.consumer.Add(data)
+}
+
+// newData build and check the event, reserved keys such as #time are extracted from properties
+func (ta *SDAnalytics) newData(accountId, distinctId, dataType, eventName, eventId string, properties map[string]interface{}) (Data, error) {
 	if len(accountId) == 0 && len(distinctId) == 0 {
 		msg := "invalid parameters: account_id and distinct_id cannot be empty at the same time"
-		sdLogError(msg)
-		return errors.New(msg)
+		ta.log.Error(msg)
+		return Data{}, errors.New(msg)
 	}
 
 	// get "#ip" value in properties, empty string will be return when not found.
-	ip := extractStringProperty(properties, "#ip")
+	ip := extractStringProperty(ta.log, properties, "#ip")
 
 	// get "#app_id" value in properties, empty string will be return when not found.
-	appId := extractStringProperty(properties, "#app_id")
+	appId := extractStringProperty(ta.log, properties, "#app_id")
 
 	// get "#time" value in properties, empty string will be return when not found.
 	eventTime, err := extractTime(properties)
 	if err != nil {
-		return err
+		ta.log.Error("invalid #time", "error", err)
+		return Data{}, err
 	}
 
-	firstCheckId := extractStringProperty(properties, "#first_check_id")
+	firstCheckId := extractStringProperty(ta.log, properties, "#first_check_id")
 
 	// get "#uuid" value in properties, empty string will be return when not found.
-	uuid := extractStringProperty(properties, "#uuid")
+	uuid := extractStringProperty(ta.log, properties, "#uuid")
 	if len(uuid) == 0 {
 		uuid = generateUUID()
 	}
@@ -294,10 +354,9 @@ func (ta *SDAnalytics) add(accountId, distinctId, dataType, eventName, eventId s
 
 	err = formatProperties(&data, ta)
 	if err != nil {
-		return err
+		return Data{}, err
 	}
-
-	return ta.consumer.Add(data)
+	return data, nil
 }
 
 // Deprecated: please use SDConsumer
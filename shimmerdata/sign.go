@@ -0,0 +1,129 @@
+package shimmerdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 签名模式使用的HTTP头
+const (
+	HeaderTimestamp = "X-SD-Timestamp"
+	HeaderNonce     = "X-SD-Nonce"
+	HeaderSignature = "X-SD-Signature"
+)
+
+// DefaultSignMaxSkew 服务端允许的客户端时间误差
+const DefaultSignMaxSkew = 5 * time.Minute
+
+// signedHeaders 参与签名的HTTP头，按此顺序拼接，请求中没有的头按空字符串计算
+var signedHeaders = []string{
+	"Content-Encoding",
+	HeaderCodec,
+	HeaderSize,
+	HeaderMd5,
+	HeaderBatchId,
+	HeaderFilename,
+	HeaderStart,
+	HeaderEnd,
+	HeaderTotal,
+}
+
+// signRequest 使用appToken对请求做HMAC-SHA256签名，appToken本身不会被发送。
+// 必须在设置完其他HTTP头之后调用
+func signRequest(req *http.Request, body []byte, app, token string) {
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nonce := generateUUID()
+	req.Header.Set(HeaderApp, app)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, computeSignature(token, req.Method, req.URL.RequestURI(), req.Header, body))
+}
+
+// computeSignature 签名内容为
+//
+//	app\ntimestamp\nnonce\nmethod\nuri\n<signedHeaders中每个头的 name:value\n>hex(sha256(body))
+func computeSignature(token, method, uri string, header http.Header, body []byte) string {
+	var b strings.Builder
+	for _, s := range []string{header.Get(HeaderApp), header.Get(HeaderTimestamp), header.Get(HeaderNonce), method, uri} {
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	for _, name := range signedHeaders {
+		b.WriteString(strings.ToLower(name))
+		b.WriteByte(':')
+		b.WriteString(header.Get(name))
+		b.WriteByte('\n')
+	}
+	bodyHash := sha256.Sum256(body)
+	b.WriteString(hex.EncodeToString(bodyHash[:]))
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(b.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureVerifier 校验SDK签名模式发送的请求，用于日志接收服务或测试用的模拟服务器
+type SignatureVerifier struct {
+	maxSkew time.Duration
+	nonces  map[string]time.Time // 最近使用过的nonce，防止重放
+	mutex   sync.Mutex
+}
+
+// NewSignatureVerifier maxSkew 为允许的时间误差，<=0 时使用 DefaultSignMaxSkew
+func NewSignatureVerifier(maxSkew time.Duration) *SignatureVerifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultSignMaxSkew
+	}
+	return &SignatureVerifier{
+		maxSkew: maxSkew,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+// Verify 校验请求的签名，包括请求方法、路径、signedHeaders和请求体。
+// body 为原始请求体，token 为该app注册时获得的appToken
+func (v *SignatureVerifier) Verify(r *http.Request, body []byte, token string) error {
+	header := r.Header
+	app := header.Get(HeaderApp)
+	timestamp := header.Get(HeaderTimestamp)
+	nonce := header.Get(HeaderNonce)
+	signature := header.Get(HeaderSignature)
+	if app == "" || timestamp == "" || nonce == "" || signature == "" {
+		return errors.New("missing signature headers")
+	}
+
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+	now := time.Now()
+	signedAt := time.UnixMilli(ms)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return fmt.Errorf("timestamp out of range: %s", timestamp)
+	}
+
+	expected := computeSignature(token, r.Method, r.URL.RequestURI(), header, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for n, t := range v.nonces {
+		if now.Sub(t) > v.maxSkew*2 {
+			delete(v.nonces, n)
+		}
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return fmt.Errorf("nonce has been used: %s", nonce)
+	}
+	v.nonces[nonce] = now
+	return nil
+}
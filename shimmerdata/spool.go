@@ -0,0 +1,253 @@
+package shimmerdata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpoolFile SDBatchConfig.TempDir 中的缓存文件
+type SpoolFile struct {
+	Name    string        //文件名
+	Path    string        //文件路径
+	Size    int64         //文件大小（压缩后）
+	ModTime time.Time     //修改时间
+	Codec   CompressCodec //压缩算法
+	Active  bool          //正在写入的 <app>-logback.log，consumer运行时不能上传或删除
+	Events  int64         //日志条数
+	Start   time.Time     //最早的#time，无法解析时为零值
+	End     time.Time     //最晚的#time，无法解析时为零值
+}
+
+// ListSpool 列出缓存目录中的所有缓存文件，按文件名排序。每个文件都会被完整读取以统计日志条数和时间范围
+func ListSpool(dir string) ([]SpoolFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]SpoolFile, 0, len(entries))
+	for _, entry := range entries {
+		//.tmp 是压缩过程中的临时文件
+		if entry.IsDir() || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		f, err := StatSpool(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// StatSpool 读取单个缓存文件，统计日志条数和时间范围
+func StatSpool(path string) (SpoolFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return SpoolFile{}, err
+	}
+	f := SpoolFile{
+		Name:    info.Name(),
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Codec:   codecFromFilename(path),
+		Active:  strings.HasSuffix(info.Name(), "-logback.log"),
+	}
+	err = ReadSpool(path, func(_ int, line []byte) error {
+		f.Events++
+		var d struct {
+			Time string `json:"#time"`
+		}
+		if json.Unmarshal(line, &d) != nil {
+			return nil
+		}
+		t, err := time.Parse(DATE_FORMAT, d.Time)
+		if err != nil {
+			return nil
+		}
+		if f.Start.IsZero() || t.Before(f.Start) {
+			f.Start = t
+		}
+		if t.After(f.End) {
+			f.End = t
+		}
+		return nil
+	})
+	return f, err
+}
+
+// spoolMarkerPrefix 缓存文件中批次标记行的前缀。发送失败的批次写入缓存文件时，先写一行
+//
+//	{"#batch_id":"<批次ID>","#batch_size":<日志条数>}
+//
+// 再写该批次的日志，重新发送时使用原来的批次ID，服务端已经接收过的批次可以据此去重
+const spoolMarkerPrefix = `{"#batch_id":`
+
+type spoolMarker struct {
+	BatchId string `json:"#batch_id"`
+	Size    int    `json:"#batch_size"`
+}
+
+// newSpoolMarker 批次标记行，包括换行符
+func newSpoolMarker(batchId string, size int) []byte {
+	bs, _ := json.Marshal(spoolMarker{BatchId: batchId, Size: size})
+	return append(bs, '\n')
+}
+
+// parseSpoolMarker line是批次标记行时返回批次ID和日志条数
+func parseSpoolMarker(line []byte) (spoolMarker, bool) {
+	var m spoolMarker
+	if !bytes.HasPrefix(line, []byte(spoolMarkerPrefix)) || json.Unmarshal(line, &m) != nil || m.BatchId == "" || m.Size <= 0 {
+		return m, false
+	}
+	return m, true
+}
+
+// ReadSpool 按扩展名解压缓存文件，对每一行非空的日志调用fn，n为从1开始的行号，批次标记行被跳过。
+// fn返回错误时停止读取，line在fn返回后不能再使用
+func ReadSpool(path string, fn func(n int, line []byte) error) error {
+	return readSpool(path, func(n int, line []byte) error {
+		if _, ok := parseSpoolMarker(line); ok {
+			return nil
+		}
+		return fn(n, line)
+	})
+}
+
+// readSpoolBatches 按批次读取缓存文件，对每个批次调用fn，lines在fn返回后不能再使用。没有批次标记的日志
+// （旧版本写入的缓存文件）按batchSize分批，批次ID由日志内容生成
+func readSpoolBatches(path string, batchSize int, fn func(batchId string, lines [][]byte) error) error {
+	var (
+		batchId string
+		size    int
+		lines   [][]byte
+	)
+	emit := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		id := batchId
+		if id == "" {
+			contents := make([]string, len(lines))
+			for i, line := range lines {
+				contents[i] = string(line)
+			}
+			id = batchIdOf(contents)
+		}
+		err := fn(id, lines)
+		lines = lines[:0]
+		return err
+	}
+	err := readSpool(path, func(_ int, line []byte) error {
+		if m, ok := parseSpoolMarker(line); ok {
+			//上一个批次不完整时也按原ID发送
+			if err := emit(); err != nil {
+				return err
+			}
+			batchId, size = m.BatchId, m.Size
+			return nil
+		}
+		lines = append(lines, append([]byte(nil), line...))
+		if batchId != "" && len(lines) >= size {
+			err := emit()
+			batchId = ""
+			return err
+		}
+		if batchId == "" && len(lines) >= batchSize {
+			return emit()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return emit()
+}
+
+// hasSpoolMarker 缓存文件中是否有批次标记
+func hasSpoolMarker(path string) (bool, error) {
+	found := errors.New("found")
+	err := readSpool(path, func(_ int, line []byte) error {
+		if _, ok := parseSpoolMarker(line); ok {
+			return found
+		}
+		return nil
+	})
+	if err == found {
+		return true, nil
+	}
+	return false, err
+}
+
+// readSpool 按扩展名解压缓存文件，对每一行非空的内容调用fn
+func readSpool(path string, fn func(n int, line []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := NewDecompressReader(file, codecFromFilename(path))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return readLines(r, fn)
+}
+
+// readLines 对每一行非空的内容调用fn，n为从1开始的行号
+func readLines(r io.Reader, fn func(n int, line []byte) error) error {
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			//超长的日志，拼接完整后再处理
+			long := append([]byte(nil), line...)
+			for errors.Is(err, bufio.ErrBufferFull) {
+				line, err = reader.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if fnErr := fn(n, trimmed); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// ReplaySpool 使用与SDBatchConsumer相同的方式重新发送缓存文件，可以发送到与原配置不同的ServerUrl。
+// 有批次标记的文件按批次使用原来的批次ID发送，旧版本写入的文件整个上传。TempDir、Interval和DedupeSize不使用。
+// 服务端不支持旧文件的压缩算法时，文件会被转为gzip，返回最终的文件路径，发送成功后由调用方决定是否删除
+func ReplaySpool(config SDBatchConfig, path string, opts ...Option) (string, error) {
+	compression, err := config.normalize()
+	if err != nil {
+		return path, err
+	}
+	o := newOptions(opts)
+	c := &SDBatchConsumer{
+		instanceLog: o.instanceLog("app", config.AppId),
+	}
+	c.abortCtx, c.abortCancel = context.WithCancel(context.Background())
+	defer c.abortCancel()
+	c.conf.Store(&config)
+	c.compression.Store(&compression)
+	return c.sendSpoolFile(path)
+}
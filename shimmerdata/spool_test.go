@@ -0,0 +1,196 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("{\"#type\":\"track\",\"#time\":\"2024-01-01 00:00:05.000\"}\n{\"#type\":\"track\",\"#time\":\"2024-01-01 00:00:01.000\"}\n")
+	encoded, err := encodeData(data, SDCompression{Codec: CodecZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "app-logback-2024-01-01T00-00-00.000.log.zst")
+	if err = os.WriteFile(backup, encoded, 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "app-logback.log"), data[:bytes.IndexByte(data, '\n')+1], 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "app-logback.log.gz.tmp"), []byte("partial"), 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ListSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expect 2 spool files, got %+v", files)
+	}
+	f := files[0]
+	start, _ := time.Parse(DATE_FORMAT, "2024-01-01 00:00:01.000")
+	end, _ := time.Parse(DATE_FORMAT, "2024-01-01 00:00:05.000")
+	if f.Active || f.Codec != CodecZstd || f.Events != 2 || !f.Start.Equal(start) || !f.End.Equal(end) {
+		t.Fatalf("unexpected spool file: %+v", f)
+	}
+	if !files[1].Active || files[1].Events != 1 {
+		t.Fatalf("unexpected active file: %+v", files[1])
+	}
+
+	// 服务端不支持zstd时转为gzip后上传
+	var uploaded []byte
+	var batchId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req LogFileUploadReq
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil || req.Codec != string(CodecGzip) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		reader, _ := NewDecompressReader(bytes.NewReader(req.Content), CodecGzip)
+		uploaded, _ = io.ReadAll(reader)
+		batchId = req.BatchId
+	}))
+	defer server.Close()
+	final, err := ReplaySpool(SDBatchConfig{ServerUrl: server.URL, AppId: "app", AppToken: "token"}, f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(final) != ".gz" || !bytes.Equal(uploaded, data) {
+		t.Fatalf("unexpected replay: %s %q", final, uploaded)
+	}
+	// 文件的批次ID由解压后的内容生成，转换压缩算法后不变
+	if sum := md5.Sum(data); batchId != hex.EncodeToString(sum[:]) {
+		t.Fatalf("batch id should be the md5 of the content: %s", batchId)
+	}
+}
+
+func TestSpoolBatchId(t *testing.T) {
+	// 服务端接收了批次但响应超时，批次写入缓存文件后重新发送时使用相同的批次ID
+	var mu sync.Mutex
+	accepted := map[string]int{} // 批次ID -> 日志条数
+	var slow atomic.Bool
+	slow.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec := CompressCodec(r.Header.Get("Content-Encoding"))
+		if codec == "" {
+			codec = CodecNone
+		}
+		reader, err := NewDecompressReader(r.Body, codec)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(reader)
+		mu.Lock()
+		accepted[r.Header.Get(HeaderBatchId)] = bytes.Count(body, []byte("\n"))
+		mu.Unlock()
+		if slow.Load() {
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := SDBatchConfig{
+		TempDir:   dir,
+		ServerUrl: server.URL,
+		AppId:     "app",
+		BatchSize: 10,
+		Interval:  60,
+		Timeout:   50 * time.Millisecond,
+		Protocol:  ProtocolV2,
+	}
+	c, err := NewBatchConsumer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+	for i := 0; i < 3; i++ {
+		if err = client.Track("", "distinct", "login", map[string]interface{}{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(accepted) != 1 {
+		t.Fatalf("retries should use the same batch id: %v", accepted)
+	}
+	var batchId string
+	for batchId = range accepted {
+	}
+	accepted = map[string]int{}
+	mu.Unlock()
+
+	// 旧版本写入的没有批次标记的文件整个上传，批次ID是内容的MD5
+	legacy := []byte("{\"#type\":\"track\",\"#uuid\":\"legacy\"}\n")
+	if err = os.WriteFile(filepath.Join(dir, "app-logback-legacy.log"), legacy, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	slow.Store(false)
+	files, err := ListSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if _, err = ReplaySpool(config, f.Path); err != nil {
+			t.Fatal(f.Name, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sum := md5.Sum(legacy)
+	if len(accepted) != 2 || accepted[batchId] != 3 || accepted[hex.EncodeToString(sum[:])] != 1 {
+		t.Fatalf("unexpected replay of %s: %v", batchId, accepted)
+	}
+}
+
+func TestReadSpoolBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-logback.log")
+	var content []byte
+	content = append(content, "{\"i\":1}\n{\"i\":2}\n{\"i\":3}\n"...)
+	content = append(content, newSpoolMarker("b1", 2)...)
+	content = append(content, "{\"i\":4}\n{\"i\":5}\n"...)
+	// 不完整的批次
+	content = append(content, newSpoolMarker("b2", 3)...)
+	content = append(content, "{\"i\":6}\n"...)
+	if err := os.WriteFile(path, content, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	var sizes []int
+	err := readSpoolBatches(path, 2, func(batchId string, lines [][]byte) error {
+		ids = append(ids, batchId)
+		sizes = append(sizes, len(lines))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := batchIdOf([]string{`{"i":1}`, `{"i":2}`})
+	if len(ids) != 4 || ids[0] != legacy || ids[2] != "b1" || ids[3] != "b2" || sizes[1] != 1 || sizes[2] != 2 || sizes[3] != 1 {
+		t.Fatalf("unexpected batches: %v %v", ids, sizes)
+	}
+	events := 0
+	if err = ReadSpool(path, func(int, []byte) error { events++; return nil }); err != nil || events != 6 {
+		t.Fatalf("ReadSpool should skip markers: %d %v", events, err)
+	}
+}
@@ -0,0 +1,138 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ProtocolVersion 日志传输协议版本
+type ProtocolVersion int
+
+const (
+	ProtocolV1 ProtocolVersion = 1 // JSON信封，日志内容以base64编码放在请求体的log/content字段中
+	ProtocolV2 ProtocolVersion = 2 // 元数据放在HTTP头中，请求体为原始的(压缩后的)NDJSON流或文件块
+)
+
+// ProtocolV2 使用的HTTP头
+const (
+	HeaderApp      = "X-SD-App"
+	HeaderToken    = "X-SD-Token"
+	HeaderSDK      = "X-SD-Sdk"
+	HeaderVersion  = "X-SD-Version"
+	HeaderSize     = "X-SD-Size"
+	HeaderCodec    = "X-SD-Codec" // 请求体或文件块的压缩算法，不压缩时为none
+	HeaderBatchId  = "X-SD-Batch-Id"
+	HeaderMd5      = "X-SD-Md5"
+	HeaderFilename = "X-SD-Filename"
+	HeaderStart    = "X-SD-Start"
+	HeaderEnd      = "X-SD-End"
+	HeaderTotal    = "X-SD-Total"
+)
+
+const (
+	reportPathV1 = "/LogServer/log/report"
+	uploadPathV1 = "/LogServer/log/upload"
+	reportPathV2 = "/LogServer/v2/log/report"
+	uploadPathV2 = "/LogServer/v2/log/upload"
+)
+
+func (v ProtocolVersion) validate() error {
+	if v != ProtocolV1 && v != ProtocolV2 {
+		return fmt.Errorf("unknown protocol version: %d", v)
+	}
+	return nil
+}
+
+// newReportRequest 创建日志上报请求
+func (c *SDBatchConsumer) newReportRequest(r *request) (*http.Request, error) {
+	conf := c.config()
+	if conf.Sign {
+		r.Token = ""
+	}
+	if conf.Protocol == ProtocolV2 {
+		req, err := c.newRequest(conf, reportPathV2, r.Log)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if r.Compress {
+			req.Header.Set("Content-Encoding", r.Codec)
+		}
+		//总是携带压缩算法，服务端不需要根据Content-Encoding推断
+		req.Header.Set(HeaderCodec, r.Codec)
+		setCommonHeader(req, r.App, r.Token, r.SDK, r.Version)
+		req.Header.Set(HeaderSize, strconv.FormatInt(r.Size, 10))
+		req.Header.Set(HeaderBatchId, r.BatchId)
+		return c.sign(conf, req, r.Log), nil
+	}
+
+	reqData, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(conf, reportPathV1, reqData)
+	if err != nil {
+		return nil, err
+	}
+	return c.sign(conf, req, reqData), nil
+}
+
+// newUploadRequest 创建日志文件块上传请求
+func (c *SDBatchConsumer) newUploadRequest(in *LogFileUploadReq) (*http.Request, error) {
+	conf := c.config()
+	if conf.Sign {
+		in.Token = ""
+	}
+	if conf.Protocol == ProtocolV2 {
+		req, err := c.newRequest(conf, uploadPathV2, in.Content)
+		if err != nil {
+			return nil, err
+		}
+		//文件块不能单独解压，不使用Content-Encoding，避免被代理解码
+		req.Header.Set("Content-Type", "application/octet-stream")
+		setCommonHeader(req, in.App, in.Token, in.Sdk, in.Version)
+		req.Header.Set(HeaderCodec, in.Codec)
+		req.Header.Set(HeaderMd5, in.Md5)
+		req.Header.Set(HeaderBatchId, in.BatchId)
+		req.Header.Set(HeaderFilename, in.Filename)
+		req.Header.Set(HeaderStart, strconv.FormatInt(in.Start, 10))
+		req.Header.Set(HeaderEnd, strconv.FormatInt(in.End, 10))
+		req.Header.Set(HeaderTotal, strconv.FormatInt(in.Total, 10))
+		return c.sign(conf, req, in.Content), nil
+	}
+
+	inData, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(conf, uploadPathV1, inData)
+	if err != nil {
+		return nil, err
+	}
+	return c.sign(conf, req, inData), nil
+}
+
+// newRequest 创建POST请求
+func (c *SDBatchConsumer) newRequest(conf *SDBatchConfig, path string, body []byte) (*http.Request, error) {
+	return http.NewRequest("POST", conf.ServerUrl+path, bytes.NewReader(body))
+}
+
+// sign 开启签名模式时对请求签名，签名包含已设置的HTTP头，所以在最后调用
+func (c *SDBatchConsumer) sign(conf *SDBatchConfig, req *http.Request, body []byte) *http.Request {
+	if conf.Sign {
+		signRequest(req, body, conf.AppId, conf.AppToken)
+	}
+	return req
+}
+
+func setCommonHeader(req *http.Request, app, token, sdk, version string) {
+	req.Header.Set(HeaderApp, app)
+	if token != "" {
+		req.Header.Set(HeaderToken, token)
+	}
+	req.Header.Set(HeaderSDK, sdk)
+	req.Header.Set(HeaderVersion, version)
+}
@@ -0,0 +1,186 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestConsumer(serverUrl string, protocol ProtocolVersion, compression SDCompression) *SDBatchConsumer {
+	c := &SDBatchConsumer{
+		instanceLog: newOptions(nil).instanceLog("app", "app"),
+	}
+	c.conf.Store(&SDBatchConfig{
+		ServerUrl: serverUrl,
+		AppId:     "app",
+		AppToken:  "token",
+		Protocol:  protocol,
+	})
+	c.abortCtx, c.abortCancel = context.WithCancel(context.Background())
+	c.compression.Store(&compression)
+	return c
+}
+
+func TestProtocolV2(t *testing.T) {
+	data := []byte("{\"#type\":\"track\"}\n{\"#type\":\"track\"}\n")
+	var reported, uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderApp) != "app" || r.Header.Get(HeaderToken) != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case reportPathV2:
+			// 不压缩时没有Content-Encoding，X-SD-Codec总是存在
+			codec := CompressCodec(r.Header.Get(HeaderCodec))
+			if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != string(codec) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reader, err := NewDecompressReader(r.Body, codec)
+			if err != nil {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			reported, _ = io.ReadAll(reader)
+		case uploadPathV2:
+			uploaded, _ = io.ReadAll(r.Body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := newTestConsumer(server.URL, ProtocolV2, SDCompression{Codec: CodecZstd})
+	if err := c.send(data, 2, "batch"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reported, data) {
+		t.Fatalf("unexpected report body: %q", reported)
+	}
+	reported = nil
+	if err := newTestConsumer(server.URL, ProtocolV2, SDCompression{Codec: CodecNone}).send(data, 2, "batch"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reported, data) {
+		t.Fatalf("unexpected uncompressed report body: %q", reported)
+	}
+
+	file := filepath.Join(t.TempDir(), "app-logback-2024-01-01T00-00-00.000.log")
+	if err := os.WriteFile(file, data, 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.uploadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(uploaded, data) {
+		t.Fatalf("unexpected upload body: %q", uploaded)
+	}
+}
+
+func TestProtocolV1(t *testing.T) {
+	data := []byte("{\"#type\":\"track\"}\n")
+	var r request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != reportPathV1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewDecoder(req.Body).Decode(&r)
+	}))
+	defer server.Close()
+
+	c := newTestConsumer(server.URL, ProtocolV1, SDCompression{Codec: CodecNone})
+	if err := c.send(data, 1, "batch"); err != nil {
+		t.Fatal(err)
+	}
+	if r.App != "app" || r.Token != "token" || r.Compress || !bytes.Equal(r.Log, data) {
+		t.Fatalf("unexpected request: %+v", r)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	verifier := NewSignatureVerifier(0)
+	var verifyErr error
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		if r.Header.Get(HeaderToken) != "" {
+			verifyErr = errors.New("token should not be sent in sign mode")
+		} else {
+			verifyErr = verifier.Verify(r, body, "token")
+		}
+		if verifyErr != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	for _, protocol := range []ProtocolVersion{ProtocolV1, ProtocolV2} {
+		c := newTestConsumer(server.URL, protocol, SDCompression{Codec: CodecGzip})
+		c.config().Sign = true
+		if err := c.send([]byte("{\"#type\":\"track\"}\n"), 1, "batch"); err != nil {
+			t.Fatal(protocol, err, verifyErr)
+		}
+		if bytes.Contains(body, []byte("token")) {
+			t.Fatalf("token should not be sent in sign mode: %s", body)
+		}
+	}
+
+	// 文件块上传
+	c := newTestConsumer(server.URL, ProtocolV2, SDCompression{})
+	c.config().Sign = true
+	req, err := c.newUploadRequest(&LogFileUploadReq{App: "app", Codec: "gzip", Md5: "md5", BatchId: "batch", Filename: "f", Content: []byte("chunk"), End: 5, Total: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if verifyErr != nil {
+		t.Fatal(verifyErr)
+	}
+
+	newSigned := func() *http.Request {
+		req := httptest.NewRequest("POST", reportPathV2, nil)
+		req.Header.Set(HeaderBatchId, "batch")
+		req.Header.Set("Content-Encoding", "gzip")
+		signRequest(req, []byte("body"), "app", "token")
+		return req
+	}
+	req = newSigned()
+	if err := verifier.Verify(req, []byte("tampered"), "token"); err == nil {
+		t.Fatal("tampered body should be rejected")
+	}
+	if err := verifier.Verify(req, []byte("body"), "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(req, []byte("body"), "token"); err == nil {
+		t.Fatal("replayed nonce should be rejected")
+	}
+
+	// 请求方法、路径和签名的HTTP头都不能被修改
+	for name, tamper := range map[string]func(r *http.Request){
+		"method":   func(r *http.Request) { r.Method = "PUT" },
+		"path":     func(r *http.Request) { r.URL.Path = uploadPathV2 },
+		"batch id": func(r *http.Request) { r.Header.Set(HeaderBatchId, "other") },
+		"encoding": func(r *http.Request) { r.Header.Del("Content-Encoding") },
+		"size":     func(r *http.Request) { r.Header.Set(HeaderSize, "1") },
+		"total":    func(r *http.Request) { r.Header.Set(HeaderTotal, "1") },
+	} {
+		req = newSigned()
+		tamper(req)
+		if err := verifier.Verify(req, []byte("body"), "token"); err == nil {
+			t.Fatalf("tampered %s should be rejected", name)
+		}
+	}
+}
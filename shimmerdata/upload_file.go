@@ -1,7 +1,6 @@
 package shimmerdata
 
 import (
-	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -14,17 +13,19 @@ import (
 )
 
 type LogFileUploadReq struct {
-	App      string `json:"app"`      //app id
-	Token    string `json:"token"`    //app token
-	Sdk      string `json:"sdk"`      //sdk类型
-	Version  string `json:"version"`  //sdk版本
-	Compress bool   `json:"compress"` //是否使用gzip压缩
-	Md5      string `json:"md5"`      //日志文件的MD5
-	Filename string `json:"filename"` //文件名
-	Start    int64  `json:"start"`    //日志写入起始位置
-	End      int64  `json:"end"`      //最后一块文件索引
-	Total    int64  `json:"total"`    //总块数
-	Content  []byte `json:"content"`  // 文件块内容
+	App      string `json:"app"`             //app id
+	Token    string `json:"token,omitempty"` //app token，签名模式下为空
+	Sdk      string `json:"sdk"`             //sdk类型
+	Version  string `json:"version"`         //sdk版本
+	Compress bool   `json:"compress"`        //是否压缩
+	Codec    string `json:"codec"`           //压缩算法
+	Md5      string `json:"md5"`             //日志文件的MD5
+	BatchId  string `json:"batch_id"`        //批次ID，使用解压后内容的MD5，重复上传或转换压缩算法后不变
+	Filename string `json:"filename"`        //文件名
+	Start    int64  `json:"start"`           //日志写入起始位置
+	End      int64  `json:"end"`             //最后一块文件索引
+	Total    int64  `json:"total"`           //总块数
+	Content  []byte `json:"content"`         // 文件块内容
 }
 
 const chunkSize int64 = 1024 * 1024 // 每块 1MB
@@ -48,18 +49,22 @@ func (c *SDBatchConsumer) uploadFile(fileDir string) error {
 		return err
 	}
 	filename := filepath.Base(fileDir)
-	compress := false
-	if filepath.Ext(fileDir) == ".gz" {
-		compress = true
+	codec := codecFromFilename(fileDir)
+	batchId, err := contentMD5(fileDir, codec)
+	if err != nil {
+		return err
 	}
 
+	conf := c.config()
 	in := &LogFileUploadReq{
-		App:      c.conf.AppId,
-		Token:    c.conf.AppToken,
+		App:      conf.AppId,
+		Token:    conf.AppToken,
 		Sdk:      "go-sdk",
 		Version:  shimmerdata_go.Version,
-		Compress: compress,
+		Compress: codec != CodecNone,
+		Codec:    string(codec),
 		Md5:      md5Str,
+		BatchId:  batchId,
 		Filename: filename,
 		Total:    fileSize,
 	}
@@ -82,16 +87,12 @@ func (c *SDBatchConsumer) uploadFile(fileDir string) error {
 		in.End = uploadedBytes + currentChunkSize
 		in.Content = buffer
 
-		inDate, err := json.Marshal(in)
-		if err != nil {
-			return err
-		}
-
 		// 准备 HTTP 请求
-		req, err := http.NewRequest("POST", c.conf.ServerUrl+"/LogServer/log/upload", bytes.NewReader(inDate))
+		req, err := c.newUploadRequest(in)
 		if err != nil {
 			return fmt.Errorf("uploadFile create POST request error: %s", err.Error())
 		}
+		req = req.WithContext(c.abortCtx)
 
 		// 执行请求
 		client := &http.Client{}
@@ -101,6 +102,10 @@ func (c *SDBatchConsumer) uploadFile(fileDir string) error {
 		}
 
 		// 检查响应状态
+		if resp.StatusCode == http.StatusUnsupportedMediaType {
+			resp.Body.Close()
+			return errUnsupportedCodec
+		}
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 			body, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
@@ -126,13 +131,32 @@ func (c *SDBatchConsumer) uploadFile(fileDir string) error {
 		resp.Body.Close()
 		// 更新已上传的字节数
 		uploadedBytes += currentChunkSize
-		sdLogInfo("%s have upload: %d/%d Bytes\n", fileDir, uploadedBytes, fileSize)
+		c.log.Info("upload log file chunk", "file", fileDir, "uploaded", uploadedBytes, "size", fileSize)
 	}
 
-	sdLogInfo("upload log file:%s success", fileDir)
+	c.log.Info("upload log file success", "file", fileDir)
 	return nil
 }
 
+// contentMD5 解压后文件内容的MD5
+func contentMD5(path string, codec CompressCodec) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	r, err := NewDecompressReader(file, codec)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func fileMD5(file *os.File) (string, error) {
 	// 创建 MD5 哈希器
 	hash := md5.New()
@@ -1,13 +1,18 @@
 package shimmerdata
 
 import (
+	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,7 +39,6 @@ func extractTime(p map[string]interface{}) (string, error) {
 			// 解析为 time.Time 对象，假设该字符串是本地时区时间
 			parsedTime, err := time.Parse(DATE_FORMAT, v)
 			if err != nil {
-				sdLogError("#time parse error:%s", err.Error())
 				return "", fmt.Errorf("#time format should be %s", DATE_FORMAT)
 			}
 			// 判断该时间是否是 UTC
@@ -61,12 +65,12 @@ func isUTC(t time.Time) bool {
 	return t.Location() == time.UTC
 }
 
-func extractStringProperty(p map[string]interface{}, key string) string {
+func extractStringProperty(log *slog.Logger, p map[string]interface{}, key string) string {
 	if t, found := p[key]; found {
 		delete(p, key)
 		v, ok := t.(string)
 		if !ok {
-			sdLogError("Invalid data type of key:%s, value:%v", key, v)
+			log.Error("invalid data type of property", "key", key, "value", t)
 		}
 		return v
 	}
@@ -76,8 +80,17 @@ func extractStringProperty(p map[string]interface{}, key string) string {
 func isNotNumber(v interface{}) bool {
 	switch v.(type) {
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-	case float32, float64:
+	case float32, float64, json.Number:
+	case nil:
+		return true
 	default:
+		// named numeric types
+		switch reflect.TypeOf(v).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return false
+		}
 		return true
 	}
 	return false
@@ -87,13 +100,19 @@ func isBuildInAttribute(v string) bool {
 	return strings.HasPrefix(v, "#")
 }
 
+// isPresetKey v is registered by RegisterPresetKey
+func isPresetKey(v string) bool {
+	_, ok := lookupPresetKey(v)
+	return ok
+}
+
 func formatProperties(d *Data, ta *SDAnalytics) error {
 
 	if d.EventName != "" {
 		matched := checkPattern([]byte(d.EventName))
 		if !matched {
 			msg := "invalid event name: " + d.EventName
-			sdLogInfo(msg)
+			ta.log.Info("invalid event name", "event", d.EventName)
 			return errors.New(msg)
 		}
 	}
@@ -104,28 +123,25 @@ func formatProperties(d *Data, ta *SDAnalytics) error {
 				isMatch := checkPattern([]byte(k))
 				if !isMatch {
 					msg := "invalid property key: " + k
-					sdLogInfo(msg)
+					ta.log.Info("invalid property key", "key", k)
 					return errors.New(msg)
 				}
 			}
 
-			if d.Type == UserAdd && !isBuildInAttribute(k) && isNotNumber(v) {
+			if d.Type == UserAdd && !isPresetKey(k) && isNotNumber(v) {
 				msg := "invalid property value: only numbers is supported by UserAdd"
-				sdLogInfo(msg)
+				ta.log.Info(msg, "key", k, "value", v)
 				return errors.New(msg)
 			}
 
-			// check value
-			switch v.(type) {
-			case int:
-			case bool:
-			case float64:
-			case string:
-			case time.Time:
-				d.Properties[k] = v.(time.Time).Format(DATE_FORMAT)
-			case []string:
-				d.IsComplex = true
-			default:
+			// check and normalize value, errors would be lost if json.Marshal failed in the consumer
+			value, complex, err := normalizeValue("properties."+k, v, 0)
+			if err != nil {
+				ta.log.Info("invalid property value", "key", k, "error", err)
+				return fmt.Errorf("invalid property value: %w", err)
+			}
+			d.Properties[k] = value
+			if complex {
 				d.IsComplex = true
 			}
 		}
@@ -134,6 +150,147 @@ func formatProperties(d *Data, ta *SDAnalytics) error {
 	return nil
 }
 
+// MaxPropertyDepth max nesting depth of objects and lists in property values
+const MaxPropertyDepth = 10
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// normalizeValue check v recursively and convert it to a value json.Marshal can not fail on: times are formatted,
+// pointers are dereferenced, nested maps and slices are copied, structs and json.Marshaler are marshaled.
+// complex reports whether v is an object or a list. path is used in errors, such as properties.items[3].id
+func normalizeValue(path string, v interface{}, depth int) (value interface{}, complex bool, err error) {
+	// common types first, without reflection
+	switch value := v.(type) {
+	case nil, bool, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v, false, nil
+	case float64:
+		return v, false, checkFloat(path, value)
+	case float32:
+		return v, false, checkFloat(path, float64(value))
+	case json.Number:
+		if _, err := value.Float64(); err != nil {
+			return nil, false, fmt.Errorf("%s: invalid number %q", path, value)
+		}
+		return v, false, nil
+	case time.Time:
+		return value.Format(DATE_FORMAT), false, nil
+	case propertyError:
+		// invalid key set by Properties, found while nested objects are copied
+		return nil, false, value.err
+	case []string:
+		return v, true, nil
+	}
+	if depth >= MaxPropertyDepth {
+		return nil, false, fmt.Errorf("%s: nested deeper than %d levels", path, MaxPropertyDepth)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, false, nil
+		}
+		// dereference unless only the pointer implements a marshaler, so *time.Time is formatted like time.Time
+		if !pointerMarshaler(rv.Type()) {
+			return normalizeValue(path, rv.Elem().Interface(), depth+1)
+		}
+	}
+	if rv.Type().Implements(jsonMarshalerType) {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return json.RawMessage(bs), true, nil
+	}
+	if rv.Type().Implements(textMarshalerType) {
+		bs, err := v.(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return string(bs), false, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// named types such as type Level int
+		return v, false, nil
+	case reflect.Float32, reflect.Float64:
+		return v, false, checkFloat(path, rv.Float())
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil, false, nil
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := mapKey(iter.Key())
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", path, err)
+			}
+			m[key], _, err = normalizeValue(path+"."+key, iter.Value().Interface(), depth+1)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		return m, true, nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, false, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return nil, false, fmt.Errorf("%s: %s is not supported, convert it to string", path, rv.Type())
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i], _, err = normalizeValue(fmt.Sprintf("%s[%d]", path, i), rv.Index(i).Interface(), depth+1)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		return list, true, nil
+	case reflect.Struct:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return json.RawMessage(bs), true, nil
+	}
+	// chan, func, complex and unsafe pointer
+	return nil, false, fmt.Errorf("%s: unsupported type %s", path, rv.Type())
+}
+
+func pointerMarshaler(t reflect.Type) bool {
+	for _, marshaler := range []reflect.Type{jsonMarshalerType, textMarshalerType} {
+		if t.Implements(marshaler) && !t.Elem().Implements(marshaler) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFloat(path string, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("%s: %v is not supported", path, f)
+	}
+	return nil
+}
+
+// mapKey key of a nested map, integer keys are converted like json.Marshal does
+func mapKey(k reflect.Value) (string, error) {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("map key of type %s is not supported", k.Type())
+}
+
 func isNotArrayOrSlice(v interface{}) bool {
 	typeOf := reflect.TypeOf(v)
 	switch typeOf.Kind() {
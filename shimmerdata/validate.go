@@ -0,0 +1,83 @@
+package shimmerdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// dataTypes all valid values of #type
+var dataTypes = map[string]bool{
+	Track:          true,
+	TrackUpdate:    true,
+	TrackOverwrite: true,
+	UserSet:        true,
+	UserUnset:      true,
+	UserSetOnce:    true,
+	UserAdd:        true,
+	UserAppend:     true,
+	UserUniqAppend: true,
+	UserDel:        true,
+}
+
+// ValidateEvent parse one line written by SDLogConsumer, SDWriterConsumer or the batch TempDir spool back
+// into Data and check it with the rules applied by SDAnalytics before an event is added. All problems are
+// returned, every error starts with the name of the field. Data is nil when the line is not valid JSON.
+func ValidateEvent(line []byte) (*Data, []error) {
+	var errs []error
+	d := &Data{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(d)
+	if err != nil {
+		// unknown fields are reported, the other fields are still checked
+		d = &Data{}
+		if json.Unmarshal(line, d) != nil {
+			return nil, []error{fmt.Errorf("invalid JSON: %w", err)}
+		}
+		errs = append(errs, err)
+	}
+
+	if !dataTypes[d.Type] {
+		errs = append(errs, pathError("", "#type", fmt.Errorf("unknown type %q", d.Type)))
+	}
+	if d.Time == "" {
+		errs = append(errs, pathError("", "#time", errors.New("can not be empty")))
+	} else if _, err = time.Parse(DATE_FORMAT, d.Time); err != nil {
+		errs = append(errs, pathError("", "#time", fmt.Errorf("should be in format %s, got %q", DATE_FORMAT, d.Time)))
+	}
+	if d.AccountId == "" && d.DistinctId == "" {
+		errs = append(errs, errors.New("#account_id, #distinct_id: can not be empty at the same time"))
+	}
+
+	switch d.Type {
+	case Track, TrackUpdate, TrackOverwrite:
+		if d.EventName == "" {
+			errs = append(errs, pathError("", "#event_name", errors.New("can not be empty")))
+		} else if !checkPattern([]byte(d.EventName)) {
+			errs = append(errs, pathError("", "#event_name", fmt.Errorf("%q does not match %s", d.EventName, KEY_PATTERN)))
+		}
+		if d.Type != Track && d.EventId == "" {
+			errs = append(errs, pathError("", "#event_id", fmt.Errorf("can not be empty for %s", d.Type)))
+		}
+	}
+
+	// sort keys to report errors in a stable order
+	keys := make([]string, 0, len(d.Properties))
+	for k := range d.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !checkPattern([]byte(k)) {
+			errs = append(errs, pathError("properties.", k, fmt.Errorf("key does not match %s", KEY_PATTERN)))
+		}
+		if d.Type == UserAdd && !isPresetKey(k) && isNotNumber(d.Properties[k]) {
+			errs = append(errs, pathError("properties.", k, errors.New("only numbers is supported by UserAdd")))
+		}
+	}
+	return d, errs
+}
@@ -0,0 +1,33 @@
+package shimmerdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEvent(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		errs []string
+	}{
+		{`{"#type":"track","#time":"2024-01-01 00:00:01.000","#distinct_id":"d","#event_name":"login","properties":{"level":1}}`, nil},
+		{`{"#type":"user_add","#time":"2024-01-01 00:00:01.000","#account_id":"a","properties":{"coin":1,"#lib":"go"}}`, nil},
+		{`{"#type":"user_add","#time":"2024-01-01 00:00:01","#account_id":"a","properties":{"coin":"1","bad key":1}}`,
+			[]string{"#time:", "properties.bad key:", "properties.coin:"}},
+		{`{"#type":"bogus","#time":"2024-01-01 00:00:01.000","extra":1}`,
+			[]string{`unknown field "extra"`, "#type:", "#account_id, #distinct_id:"}},
+		{`{"#type":"track_overwrite","#time":"2024-01-01 00:00:01.000","#distinct_id":"d"}`,
+			[]string{"#event_name:", "#event_id:"}},
+		{`not json`, []string{"invalid JSON"}},
+	} {
+		_, errs := ValidateEvent([]byte(c.line))
+		if len(errs) != len(c.errs) {
+			t.Fatalf("%s: expect %d errors, got %v", c.line, len(c.errs), errs)
+		}
+		for i, err := range errs {
+			if !strings.Contains(err.Error(), c.errs[i]) {
+				t.Fatalf("%s: expect error %q, got %v", c.line, c.errs[i], err)
+			}
+		}
+	}
+}
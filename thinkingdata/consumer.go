@@ -0,0 +1,134 @@
+package thinkingdata
+
+import (
+	"os"
+	"time"
+
+	"github.com/ShimmerGames-Co-Ltd/shimmerdata-go/shimmerdata"
+)
+
+// RotateMode rotate mode of log file
+type RotateMode = shimmerdata.RotateMode
+
+const (
+	ROTATE_DAILY  = shimmerdata.RotateDaily  // by the day
+	ROTATE_HOURLY = shimmerdata.RotateHourly // by the hour
+)
+
+const (
+	DefaultTimeOut       = shimmerdata.DefaultTimeOut
+	DefaultBatchSize     = shimmerdata.DefaultBatchSize
+	MaxBatchSize         = shimmerdata.MaxBatchSize
+	DefaultInterval      = shimmerdata.DefaultInterval
+	DefaultCacheCapacity = 50
+)
+
+// AppTokenEnv environment variable of the app token, used when TDBatchConfig.AppToken is empty.
+// ThinkingData does not need a token, so the constructors without config read it from here.
+const AppTokenEnv = shimmerdata.EnvPrefix + "BATCH_APP_TOKEN"
+
+// TDLogConsumerConfig config of the log consumer
+type TDLogConsumerConfig struct {
+	Directory      string     // directory of log file
+	RotateMode     RotateMode // rotate mode of log file
+	FileSize       int        // max size of single log file (MByte)
+	FileNamePrefix string     // prefix of log file
+	ChannelSize    int
+}
+
+// NewLogConsumer init log consumer
+func NewLogConsumer(directory string, r RotateMode) (TDConsumer, error) {
+	return shimmerdata.NewLogConsumer(directory, r)
+}
+
+// NewLogConsumerWithFileSize init log consumer, size is the max size of single log file (MByte)
+func NewLogConsumerWithFileSize(directory string, r RotateMode, size int) (TDConsumer, error) {
+	return shimmerdata.NewLogConsumerWithFileSize(directory, r, size)
+}
+
+// NewLogConsumerWithConfig init log consumer
+func NewLogConsumerWithConfig(config TDLogConsumerConfig) (TDConsumer, error) {
+	return shimmerdata.NewLogConsumerWithConfig(shimmerdata.SDLogConsumerConfig{
+		Directory:      config.Directory,
+		RotateMode:     config.RotateMode,
+		FileSize:       config.FileSize,
+		FileNamePrefix: config.FileNamePrefix,
+		ChannelSize:    config.ChannelSize,
+	})
+}
+
+// TDBatchConfig config of the batch consumer
+type TDBatchConfig struct {
+	ServerUrl     string // serverUrl
+	AppId         string // appId
+	BatchSize     int    // flush event count each time
+	Timeout       int    // http timeout (mill second)
+	Compress      bool   // enable compress data
+	AutoFlush     bool   // ignored, data is always flushed every Interval
+	Interval      int    // auto flush spacing (second)
+	CacheCapacity int    // ignored, events that failed to send are kept in TempDir instead
+	AppToken      string // shimmerdata only: app token, read from AppTokenEnv when empty
+	TempDir       string // shimmerdata only: directory to keep events that failed to send, see shimmerdata.SDBatchConfig
+}
+
+// NewBatchConsumer init batch consumer
+func NewBatchConsumer(serverUrl string, appId string) (TDConsumer, error) {
+	return NewBatchConsumerWithConfig(TDBatchConfig{
+		ServerUrl: serverUrl,
+		AppId:     appId,
+		Compress:  true,
+	})
+}
+
+// NewBatchConsumerWithBatchSize init batch consumer with batch size
+func NewBatchConsumerWithBatchSize(serverUrl string, appId string, batchSize int) (TDConsumer, error) {
+	return NewBatchConsumerWithConfig(TDBatchConfig{
+		ServerUrl: serverUrl,
+		AppId:     appId,
+		BatchSize: batchSize,
+		Compress:  true,
+	})
+}
+
+// NewBatchConsumerWithCompress init batch consumer, compress decides whether data is compressed by gzip
+func NewBatchConsumerWithCompress(serverUrl string, appId string, compress bool) (TDConsumer, error) {
+	return NewBatchConsumerWithConfig(TDBatchConfig{
+		ServerUrl: serverUrl,
+		AppId:     appId,
+		Compress:  compress,
+	})
+}
+
+// NewBatchConsumerWithConfig init batch consumer
+func NewBatchConsumerWithConfig(config TDBatchConfig) (TDConsumer, error) {
+	token := config.AppToken
+	if token == "" {
+		token = os.Getenv(AppTokenEnv)
+	}
+	return shimmerdata.NewBatchConsumer(shimmerdata.SDBatchConfig{
+		TempDir:   config.TempDir,
+		ServerUrl: config.ServerUrl,
+		AppId:     config.AppId,
+		AppToken:  token,
+		BatchSize: config.BatchSize,
+		Timeout:   time.Duration(config.Timeout) * time.Millisecond,
+		Compress:  config.Compress,
+		Interval:  config.Interval,
+	})
+}
+
+// NewDebugConsumer init debug consumer. Unlike ThinkingData, events are not sent to the server but printed
+// to stdout without buffering, and property names are checked strictly.
+func NewDebugConsumer(serverUrl string, appId string) (TDConsumer, error) {
+	return NewDebugConsumerWithWriter(serverUrl, appId, true)
+}
+
+// NewDebugConsumerWithWriter init debug consumer, writeData is ignored. see NewDebugConsumer
+func NewDebugConsumerWithWriter(serverUrl string, appId string, writeData bool) (TDConsumer, error) {
+	return shimmerdata.NewWriterConsumer(os.Stdout, shimmerdata.SDWriterConsumerConfig{BufferSize: -1, Stringent: true})
+}
+
+// NewDebugConsumerWithDeviceId init debug consumer, writeData and deviceId are ignored. see NewDebugConsumer
+func NewDebugConsumerWithDeviceId(serverUrl string, appId string, writeData bool, deviceId string) (TDConsumer, error) {
+	return NewDebugConsumerWithWriter(serverUrl, appId, writeData)
+}
@@ -0,0 +1,69 @@
+// Package thinkingdata mirrors the exported API of the ThinkingData Go SDK
+// (github.com/ThinkingDataAnalytics/go-sdk/v2/src/thinkingdata) on top of shimmerdata, so migrating an
+// application only needs to change the import path:
+//
+//	import "github.com/ShimmerGames-Co-Ltd/shimmerdata-go/thinkingdata"
+//
+// Events are written by the shimmerdata consumers in the shimmerdata format, which is compatible with
+// ThinkingData. Options that have no counterpart in shimmerdata are accepted and ignored, see the comments
+// of the config fields. New code should use package shimmerdata directly.
+package thinkingdata
+
+import (
+	"github.com/ShimmerGames-Co-Ltd/shimmerdata-go/shimmerdata"
+)
+
+// Data the event written by consumers
+type Data = shimmerdata.Data
+
+// TDConsumer define operation interface, any shimmerdata consumer can be used
+type TDConsumer = shimmerdata.SDConsumer
+
+// event types
+const (
+	Track          = shimmerdata.Track
+	TrackUpdate    = shimmerdata.TrackUpdate
+	TrackOverwrite = shimmerdata.TrackOverwrite
+	UserSet        = shimmerdata.UserSet
+	UserUnset      = shimmerdata.UserUnset
+	UserSetOnce    = shimmerdata.UserSetOnce
+	UserAdd        = shimmerdata.UserAdd
+	UserAppend     = shimmerdata.UserAppend
+	UserUniqAppend = shimmerdata.UserUniqAppend
+	UserDel        = shimmerdata.UserDel
+)
+
+// TDAnalytics the SDK instance. shimmerdata.SDAnalytics is embedded, so the methods of shimmerdata such as
+// Shutdown are available too.
+type TDAnalytics struct {
+	*shimmerdata.SDAnalytics
+}
+
+// New init SDK
+func New(c TDConsumer) TDAnalytics {
+	return TDAnalytics{SDAnalytics: shimmerdata.New(c)}
+}
+
+// TDLogLevel level of the SDK internal log
+type TDLogLevel = shimmerdata.SDLogLevel
+
+const (
+	TDLogLevelOff     = shimmerdata.SDLogLevelOff
+	TDLogLevelError   = shimmerdata.SDLogLevelError
+	TDLogLevelWarning = shimmerdata.SDLogLevelWarning
+	TDLogLevelInfo    = shimmerdata.SDLogLevelInfo
+	TDLogLevelDebug   = shimmerdata.SDLogLevelDebug
+)
+
+// TDLogger User-defined log classes must comply with interface
+type TDLogger = shimmerdata.SDLogger
+
+// SetLogLevel Set the log output level of the SDK
+func SetLogLevel(level TDLogLevel) {
+	shimmerdata.SetLogLevel(level)
+}
+
+// SetCustomLogger Set the log output of the SDK, usually you don't need to set it up.
+func SetCustomLogger(logger TDLogger) {
+	shimmerdata.SetCustomLogger(logger)
+}
@@ -0,0 +1,115 @@
+package thinkingdata
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type memoryConsumer struct {
+	data []Data
+}
+
+func (c *memoryConsumer) Add(d Data) error {
+	c.data = append(c.data, d)
+	return nil
+}
+func (c *memoryConsumer) Flush() error      { return nil }
+func (c *memoryConsumer) Close() error      { return nil }
+func (c *memoryConsumer) IsStringent() bool { return false }
+
+// TestCompatibleAPI call sites written for the ThinkingData SDK compile and work unchanged
+func TestCompatibleAPI(t *testing.T) {
+	consumer := &memoryConsumer{}
+	var c TDConsumer = consumer
+	ta := New(c)
+	ta.SetSuperProperties(map[string]interface{}{"channel": "ta"})
+	ta.SetDynamicSuperProperties(func() map[string]interface{} {
+		return map[string]interface{}{"dynamic": true}
+	})
+	if err := ta.Track("account", "distinct", "login", map[string]interface{}{"level": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.UserSetOnce("account", "distinct", map[string]interface{}{"first": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.UserAdd("account", "distinct", map[string]interface{}{"coin": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.TrackUpdate("account", "distinct", "order", "id", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(consumer.data) != 4 {
+		t.Fatalf("expect 4 events, got %d", len(consumer.data))
+	}
+	d := consumer.data[0]
+	if d.Type != Track || d.EventName != "login" || d.Properties["channel"] != "ta" || d.Properties["dynamic"] != true {
+		t.Fatalf("unexpected event: %+v", d)
+	}
+	if consumer.data[1].Type != UserSetOnce || consumer.data[2].Type != UserAdd || consumer.data[3].Type != TrackUpdate {
+		t.Fatalf("unexpected types: %+v", consumer.data)
+	}
+}
+
+func TestLogConsumer(t *testing.T) {
+	dir := t.TempDir()
+	consumer, err := NewLogConsumerWithConfig(TDLogConsumerConfig{
+		Directory:      dir,
+		RotateMode:     ROTATE_DAILY,
+		FileNamePrefix: "ta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := New(consumer)
+	if err = ta.Track("account", "distinct", "login", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "ta*"))
+	if len(files) != 1 {
+		t.Fatalf("expect 1 log file, got %v", files)
+	}
+	content, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(content), `"#event_name":"login"`) {
+		t.Fatalf("unexpected content: %s", content)
+	}
+}
+
+func TestBatchConsumerAppToken(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	// ThinkingData has no token, the constructors read it from AppTokenEnv
+	t.Setenv(AppTokenEnv, "env-token")
+	consumer, err := NewBatchConsumer(server.URL, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := New(consumer)
+	if err = ta.Track("account", "distinct", "login", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"token":"env-token"`) {
+		t.Fatalf("token should be read from %s: %s", AppTokenEnv, body)
+	}
+}